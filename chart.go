@@ -0,0 +1,93 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Size of the chart, and of the margin around the plot holding the labels
+const (
+	chartWidth  = 800
+	chartHeight = 400
+	chartMargin = 60
+)
+
+// writeChart renders the size of every history entry over time to the SVG
+// file given with --chart, if any.
+func (m *model) writeChart() {
+	if len(*flagChart) == 0 {
+		return
+	}
+	var items []listItem
+	for _, item := range m.list.Items() {
+		if li, ok := item.(listItem); ok {
+			items = append(items, li)
+		}
+	}
+	slices.SortFunc(items, func(a, b listItem) int { return cmp.Compare(a.id, b.id) })
+
+	var (
+		xs = make([]time.Time, 0, len(items))
+		ys = make([]float64, 0, len(items))
+	)
+	for _, li := range items {
+		xs = append(xs, li.t)
+		ys = append(ys, float64(li.nChars))
+	}
+
+	err := errors.New("not enough outputs to chart")
+	if len(items) >= 2 {
+		err = renderChart(*flagChart, xs, ys)
+	}
+	if err != nil {
+		printErrf("Cannot write chart: %v", err)
+	}
+}
+
+// renderChart plots ys over the times xs, in order, as a line from zero up to
+// the largest of ys.
+func renderChart(path string, xs []time.Time, ys []float64) error {
+	var (
+		plotW = float64(chartWidth - 2*chartMargin)
+		plotH = float64(chartHeight - 2*chartMargin)
+		span  = max(xs[len(xs)-1].Sub(xs[0]), 1)
+		ymax  = max(slices.Max(ys), 1)
+	)
+	points := make([]string, 0, len(xs))
+	for i, x := range xs {
+		px := chartMargin + float64(x.Sub(xs[0]))/float64(span)*plotW
+		py := chartMargin + plotH - ys[i]/ymax*plotH
+		points = append(points, fmt.Sprintf("%.1f,%.1f", px, py))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n",
+		chartWidth, chartHeight)
+	sb.WriteString(`<rect width="100%" height="100%" fill="white"/>` + "\n")
+	fmt.Fprintf(&sb, `<text x="%d" y="%d" text-anchor="middle" font-size="16">Output size</text>`+"\n",
+		chartWidth/2, chartMargin/2)
+	// Axes, then their labels
+	fmt.Fprintf(&sb, `<path d="M%d %d V%d H%d" fill="none" stroke="black"/>`+"\n",
+		chartMargin, chartMargin, chartHeight-chartMargin, chartWidth-chartMargin)
+	fmt.Fprintf(&sb, `<text x="%d" y="%d" text-anchor="end">%g</text>`+"\n",
+		chartMargin-5, chartMargin+4, ymax)
+	fmt.Fprintf(&sb, `<text x="%d" y="%d" text-anchor="end">0</text>`+"\n",
+		chartMargin-5, chartHeight-chartMargin+4)
+	fmt.Fprintf(&sb, `<text x="%d" y="%d" text-anchor="middle" transform="rotate(-90 %[1]d %[2]d)">bytes</text>`+"\n",
+		chartMargin/3, chartHeight/2)
+	for i, x := range []int{chartMargin, chartWidth - chartMargin} {
+		t := xs[i*(len(xs)-1)]
+		fmt.Fprintf(&sb, `<text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n",
+			x, chartHeight-chartMargin+18, t.Format(time.TimeOnly))
+	}
+	fmt.Fprintf(&sb, `<polyline points="%s" fill="none" stroke="steelblue" stroke-width="2"/>`+"\n",
+		strings.Join(points, " "))
+	sb.WriteString("</svg>\n")
+
+	return os.WriteFile(path, []byte(sb.String()), 0o600)
+}
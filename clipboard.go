@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/atotto/clipboard"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// copiedMsg tells how copying what to the clipboard went.
+type copiedMsg struct {
+	what string
+	// Whether the terminal was asked to copy, which cannot be confirmed
+	osc52 bool
+	err   error
+}
+
+func (msg copiedMsg) String() string {
+	switch {
+	case msg.err != nil:
+		return fmt.Sprintf("Cannot copy %s: %v", msg.what, msg.err)
+	case msg.osc52:
+		return fmt.Sprintf("Sent %s to the terminal clipboard", msg.what)
+	default:
+		return "Copied " + msg.what
+	}
+}
+
+// copyToClipboard puts text on the system clipboard. Without one, e.g. over
+// SSH, the terminal is asked to copy it with OSC 52.
+func copyToClipboard(text, what string) tea.Cmd {
+	return func() tea.Msg {
+		err := clipboard.WriteAll(text)
+		if err == nil {
+			return copiedMsg{what: what, osc52: false, err: nil}
+		}
+		slog.Debug("No system clipboard, falling back to OSC 52", "err", err)
+		// The terminal is released first, so that the sequence is not
+		// written in the middle of a frame
+		return tea.Exec(&osc52Copy{text: text, stdout: os.Stdout}, func(err error) tea.Msg {
+			return copiedMsg{what: what, osc52: true, err: err}
+		})()
+	}
+}
+
+// osc52Copy writes the OSC 52 sequence copying text to the terminal.
+type osc52Copy struct {
+	text   string
+	stdout io.Writer
+}
+
+func (c *osc52Copy) Run() error {
+	_, err := io.WriteString(c.stdout, ansi.SetSystemClipboard(c.text))
+	return err
+}
+
+func (c *osc52Copy) SetStdin(io.Reader) {}
+
+func (c *osc52Copy) SetStdout(w io.Writer) { c.stdout = w }
+
+func (c *osc52Copy) SetStderr(io.Writer) {}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Commands accepted on the control socket
+const (
+	controlPause    = "pause"
+	controlResume   = "resume"
+	controlRefresh  = "refresh"
+	controlQuit     = "quit"
+	controlInterval = "interval"
+)
+
+const controlGreeting = "a555watch control, one command per line: " +
+	controlPause + ", " + controlResume + ", " + controlRefresh + ", " + controlQuit + ", " +
+	controlInterval + " DURATION\n"
+
+// controlMsg is a command received on the control socket.
+type controlMsg struct {
+	cmd      string
+	interval time.Duration
+}
+
+// parseControl parses a line received on the control socket.
+func parseControl(line string) (controlMsg, error) {
+	msg := controlMsg{cmd: "", interval: 0}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return msg, errors.New("empty command")
+	}
+	msg.cmd = fields[0]
+	switch msg.cmd {
+	case controlPause, controlResume, controlRefresh, controlQuit:
+		if len(fields) > 1 {
+			return msg, fmt.Errorf("%s takes no argument", msg.cmd)
+		}
+	case controlInterval:
+		if len(fields) != 2 {
+			return msg, fmt.Errorf("%s takes a duration", msg.cmd)
+		}
+		d, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return msg, err
+		}
+		if d <= 0 {
+			return msg, fmt.Errorf("%s must be positive", msg.cmd)
+		}
+		msg.interval = d
+	default:
+		return msg, fmt.Errorf("unknown command %q", msg.cmd)
+	}
+	return msg, nil
+}
+
+// listenControl accepts connections on the Unix socket at path, and forwards
+// the commands they send to the program.
+func listenControl(path string, p *tea.Program) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				slog.Debug("Control socket closed", "err", err)
+				return
+			}
+			go serveControl(conn, p)
+		}
+	}()
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket at path if nothing listens on it, as
+// left behind by a watch that got killed. Anything else is left to fail the
+// listening.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode().Type() != fs.ModeSocket {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	slog.Info("Removing stale control socket", "path", path)
+	return os.Remove(path)
+}
+
+func serveControl(conn net.Conn, p *tea.Program) {
+	defer conn.Close()
+	if _, err := fmt.Fprint(conn, controlGreeting); err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		msg, err := parseControl(scanner.Text())
+		if err != nil {
+			fmt.Fprintf(conn, "error: %v\n", err)
+			continue
+		}
+		slog.Info("Control command", "cmd", msg.cmd)
+		p.Send(msg)
+		fmt.Fprintln(conn, "ok")
+	}
+}
+
+// handleControl applies a command received on the control socket.
+func (m *model) handleControl(msg controlMsg) tea.Cmd {
+	switch msg.cmd {
+	case controlPause:
+		m.blurred = false
+		if !m.paused {
+			m.paused = true
+			return m.timer.Stop()
+		}
+	case controlResume:
+		m.blurred = false
+		if m.paused {
+			m.paused = false
+			return m.timer.Start()
+		}
+	case controlRefresh:
+		return m.runNow()
+	case controlQuit:
+		return tea.Quit
+	case controlInterval:
+		m.interval = msg.interval
+	}
+	return nil
+}
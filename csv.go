@@ -0,0 +1,59 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+var csvHeader = []string{"time", "bytes", "lines", "lev", "additions", "deletions", "exit", "duration"}
+
+// writeCSV writes the metrics of every history entry to the file given with
+// --csv, if any, oldest first.
+func (m *model) writeCSV() {
+	if len(*flagCSV) == 0 {
+		return
+	}
+	m.computeMetrics()
+	var items []listItem
+	for _, item := range m.list.Items() {
+		if li, ok := item.(listItem); ok {
+			items = append(items, li)
+		}
+	}
+	slices.SortFunc(items, func(a, b listItem) int { return cmp.Compare(a.id, b.id) })
+
+	optional := func(v *int) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(*v)
+	}
+	records := [][]string{csvHeader}
+	for _, li := range items {
+		records = append(records, []string{
+			li.t.Format(time.RFC3339Nano),
+			fmt.Sprint(li.nChars),
+			fmt.Sprint(li.nLines),
+			optional(li.levDist),
+			optional(li.additions),
+			optional(li.deletions),
+			fmt.Sprint(li.exit),
+			fmt.Sprint(li.dur.Seconds()),
+		})
+	}
+
+	f, err := os.OpenFile(*flagCSV, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err == nil {
+		err = csv.NewWriter(f).WriteAll(records)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		printErrf("Cannot write CSV file: %v", err)
+	}
+}
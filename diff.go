@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// renderedDiff is a diff rendered for display, along with the lines where each
+// of its changes starts.
+type renderedDiff struct {
+	text  string
+	hunks []int
+}
+
+// Lines shown above a change when jumping to it
+const hunkContext = 2
+
+// diffHunks finds the line where each run of insertions and deletions starts
+// in the char diff as rendered.
+func diffHunks(diffs []diffmatchpatch.Diff) []int {
+	var (
+		hunks  []int
+		line   int
+		inHunk bool
+	)
+	for _, d := range diffs {
+		if len(d.Text) == 0 {
+			continue
+		}
+		if d.Type != diffmatchpatch.DiffEqual && !inHunk {
+			hunks = append(hunks, line)
+		}
+		inHunk = d.Type != diffmatchpatch.DiffEqual
+		line += strings.Count(d.Text, "\n")
+	}
+	return hunks
+}
+
+// jumpToChange scrolls the pager to the next change of the diff shown, or to
+// the previous one.
+func (m *model) jumpToChange(next bool) {
+	// The change at the top of the pager, past its context
+	cur := m.pager.YOffset + hunkContext
+	var target int
+	if next {
+		i, _ := slices.BinarySearch(m.hunks, cur+1)
+		if i == len(m.hunks) {
+			return
+		}
+		target = m.hunks[i]
+	} else {
+		i, _ := slices.BinarySearch(m.hunks, cur)
+		if i == 0 {
+			return
+		}
+		target = m.hunks[i-1]
+	}
+	m.pager.SetYOffset(max(target-hunkContext, 0))
+}
+
+// Lines of context around the changes of a patch
+const patchContext = 3
+
+// patchLine is a line of a patch: its marker, its text and whether it ends
+// with a newline.
+type patchLine struct {
+	op   byte
+	text string
+	eol  bool
+}
+
+// unifiedDiff renders a line diff as a unified patch, with the changes
+// grouped in hunks along with the lines around them.
+func unifiedDiff(fromLabel, toLabel string, diffs []diffmatchpatch.Diff) string {
+	var lines []patchLine
+	for _, d := range diffs {
+		op := byte(' ')
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			op = '+'
+		case diffmatchpatch.DiffDelete:
+			op = '-'
+		case diffmatchpatch.DiffEqual:
+		}
+		for text := d.Text; len(text) > 0; {
+			var (
+				l   string
+				eol bool
+			)
+			l, text, eol = strings.Cut(text, "\n")
+			lines = append(lines, patchLine{op: op, text: l, eol: eol})
+		}
+	}
+
+	var (
+		sb strings.Builder
+		// Lines of each side before the hunk
+		oldN, newN int
+		done       int
+	)
+	count := func(from, to int) (nOld, nNew int) {
+		for _, l := range lines[from:to] {
+			if l.op != '+' {
+				nOld++
+			}
+			if l.op != '-' {
+				nNew++
+			}
+		}
+		return nOld, nNew
+	}
+	hunkRange := func(before, n int) string {
+		switch n {
+		case 0:
+			return fmt.Sprintf("%d,0", before)
+		case 1:
+			return strconv.Itoa(before + 1)
+		default:
+			return fmt.Sprintf("%d,%d", before+1, n)
+		}
+	}
+	for i := 0; i < len(lines); {
+		for i < len(lines) && lines[i].op == ' ' {
+			i++
+		}
+		if i == len(lines) {
+			break
+		}
+		// Changes closer than twice the context share a hunk
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].op != ' ' {
+				end = j + 1
+			} else if j-end >= 2*patchContext {
+				break
+			}
+		}
+		start, stop := max(i-patchContext, 0), min(end+patchContext, len(lines))
+		o, n := count(done, start)
+		oldN, newN = oldN+o, newN+n
+		o, n = count(start, stop)
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromLabel, toLabel)
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(oldN, o), hunkRange(newN, n))
+		for _, l := range lines[start:stop] {
+			sb.WriteByte(l.op)
+			sb.WriteString(l.text)
+			sb.WriteString("\n")
+			if !l.eol {
+				sb.WriteString("\\ No newline at end of file\n")
+			}
+		}
+		oldN, newN, done, i = oldN+o, newN+n, stop, stop
+	}
+	return sb.String()
+}
+
+// copyPatch puts the line diff of the selected entry on the clipboard as a
+// unified patch.
+func (m *model) copyPatch() tea.Cmd {
+	if m.seleID == nil || m.raw || m.diff == diffOff {
+		return m.toast("No diff to copy")
+	}
+	from, to := m.diffIDs(*m.seleID)
+	if from == nil {
+		return m.toast("No diff to copy for the oldest entry")
+	}
+	label := func(id entryID) string {
+		return "output\t" + m.hist[id].t.Format(time.RFC3339)
+	}
+	patch := unifiedDiff("a/"+label(*from), "b/"+label(*to),
+		m.lineDiffs(m.hist[*from].text(m.stream), m.hist[*to].text(m.stream)))
+	if len(patch) == 0 {
+		return m.toast("No changes to copy")
+	}
+	return copyToClipboard(patch, fmt.Sprintf("a patch of %d lines", strings.Count(patch, "\n")))
+}
+
+// How the unchanged lines of a line diff are shown
+type unchangedMode uint
+
+const (
+	unchangedShow unchangedMode = iota
+	unchangedDim
+	// Only those around the changes
+	unchangedFold
+	numUnchangedModes
+)
+
+func (u unchangedMode) String() string {
+	switch u {
+	case unchangedDim:
+		return "dim"
+	case unchangedFold:
+		return "fold"
+	default:
+		return "show"
+	}
+}
+
+// renderLineDiff renders a line-level diff with a gutter marking each line as
+// added (+), removed (-) or unchanged. Unchanged lines are dimmed or folded
+// according to the mode.
+func renderLineDiff(diffs []diffmatchpatch.Diff, unchanged unchangedMode) renderedDiff {
+	var (
+		sb     strings.Builder
+		hunks  []int
+		line   int
+		inHunk bool
+	)
+	for i, d := range diffs {
+		if len(d.Text) == 0 {
+			continue
+		}
+		var (
+			marker string
+			sty    lipgloss.Style
+		)
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			marker, sty = "+", diffInsStyle
+		case diffmatchpatch.DiffDelete:
+			marker, sty = "-", diffDelStyle
+		case diffmatchpatch.DiffEqual:
+			marker, sty = " ", lipgloss.NewStyle().TabWidth(lipgloss.NoTabConversion)
+			if unchanged == unchangedDim {
+				sty = diffDimStyle
+			}
+		}
+		if d.Type != diffmatchpatch.DiffEqual && !inHunk {
+			hunks = append(hunks, line)
+		}
+		lines := strings.Split(strings.TrimSuffix(d.Text, "\n"), "\n")
+		// Lines to keep before and after the folded ones
+		head, tail := len(lines), 0
+		if d.Type == diffmatchpatch.DiffEqual && unchanged == unchangedFold {
+			head = 0
+			if inHunk {
+				head = hunkContext
+			}
+			if i < len(diffs)-1 {
+				tail = hunkContext
+			}
+			if len(lines) <= head+tail+1 {
+				head = len(lines)
+			}
+		}
+		for j, l := range lines {
+			switch {
+			case j == head && j < len(lines)-tail:
+				sb.WriteString(pagerSepStyle.Render(fmt.Sprintf("  ⋯ %d unchanged lines", len(lines)-head-tail)))
+			case j > head && j < len(lines)-tail:
+				continue
+			default:
+				sb.WriteString(sty.Render(marker))
+				sb.WriteString(" ")
+				if d.Type == diffmatchpatch.DiffEqual {
+					// The highlights reset the style, which is then
+					// applied around each of them
+					sb.WriteString(highlightLine(l, sty))
+				} else {
+					sb.WriteString(sty.Render(l))
+				}
+			}
+			if j < len(lines)-1 || strings.HasSuffix(d.Text, "\n") {
+				sb.WriteString("\n")
+				line++
+			}
+		}
+		inHunk = d.Type != diffmatchpatch.DiffEqual
+	}
+	return renderedDiff{text: sb.String(), hunks: hunks}
+}
+
+// renderCharDiff renders a character-level diff, coloring insertions and
+// deletions. With markers they are also enclosed in {+ +} and [- -], so that
+// they stand out without colors.
+func renderCharDiff(diffs []diffmatchpatch.Diff, markers bool) string {
+	if markers {
+		diffs = markDiffs(diffs)
+	}
+	var sb strings.Builder
+	for _, d := range diffs {
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			sb.WriteString(renderLines(diffInsStyle, d.Text))
+		case diffmatchpatch.DiffDelete:
+			sb.WriteString(renderLines(diffDelStyle, d.Text))
+		case diffmatchpatch.DiffEqual:
+			sb.WriteString(highlightText(d.Text))
+		}
+	}
+	return sb.String()
+}
+
+// markDiffs encloses the insertions of diffs in {+ +} and the deletions in
+// [- -].
+func markDiffs(diffs []diffmatchpatch.Diff) []diffmatchpatch.Diff {
+	marked := make([]diffmatchpatch.Diff, 0, len(diffs))
+	for _, d := range diffs {
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			d.Text = "{+" + d.Text + "+}"
+		case diffmatchpatch.DiffDelete:
+			d.Text = "[-" + d.Text + "-]"
+		case diffmatchpatch.DiffEqual:
+		}
+		marked = append(marked, d)
+	}
+	return marked
+}
+
+// renderLines renders each line of s on its own, so that they are not padded
+// to the same width.
+func renderLines(sty lipgloss.Style, s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if len(line) > 0 {
+			lines[i] = sty.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var hexColorRe = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// validateColor checks that s is an ANSI color index or a hex color.
+func validateColor(s string) error {
+	if hexColorRe.MatchString(s) {
+		return nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n <= 255 {
+		return nil
+	}
+	return fmt.Errorf("invalid color %q (want an ANSI index in 0-255 or a hex color)", s)
+}
+
+// Cleanup passes of char diffs
+const (
+	// Shift the changes to line up with word boundaries
+	cleanupSemantic = "semantic"
+	// Merge the changes which are cheaper to show as a single one
+	cleanupEfficiency = "efficiency"
+	cleanupNone       = "none"
+)
+
+func validateDiffCleanup(cleanup string) error {
+	switch cleanup {
+	case cleanupSemantic, cleanupEfficiency, cleanupNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid diff cleanup %q (want %s, %s or %s)",
+			cleanup, cleanupSemantic, cleanupEfficiency, cleanupNone)
+	}
+}
+
+// newDiffMatchPatch makes a differ configured by the options.
+func newDiffMatchPatch() *diffmatchpatch.DiffMatchPatch {
+	dmp := diffmatchpatch.New()
+	dmp.DiffTimeout = *flagDiffTO
+	dmp.DiffEditCost = *flagEditCost
+	return dmp
+}
+
+// diffOp is an operation of a diff as written with --output-format=diffjson.
+type diffOp struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// diffExport holds the line and char diffs of an entry with the one it is
+// compared with, which is missing for the first entry.
+type diffExport struct {
+	From *time.Time `json:"from"`
+	To   time.Time  `json:"to"`
+	Line []diffOp   `json:"line"`
+	Char []diffOp   `json:"char"`
+}
+
+func diffOps(diffs []diffmatchpatch.Diff) []diffOp {
+	ops := make([]diffOp, 0, len(diffs))
+	for _, d := range diffs {
+		op := "equal"
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			op = "insert"
+		case diffmatchpatch.DiffDelete:
+			op = "delete"
+		case diffmatchpatch.DiffEqual:
+		}
+		ops = append(ops, diffOp{Op: op, Text: d.Text})
+	}
+	return ops
+}
+
+// diffJSON serializes the diffs of the entry id, in the stream shown and
+// against the entry it is compared with in the pager.
+func (m model) diffJSON(id entryID) string {
+	from, to := m.diffIDs(id)
+	exp := diffExport{From: nil, To: m.hist[*to].t, Line: nil, Char: nil}
+	var fromText string
+	if from != nil {
+		exp.From = &m.hist[*from].t
+		fromText = m.hist[*from].text(m.stream)
+	}
+	toText := m.hist[*to].text(m.stream)
+	exp.Line = diffOps(m.lineDiffs(fromText, toText))
+	exp.Char = diffOps(m.charDiffs(fromText, toText))
+	data, err := json.MarshalIndent(exp, "", "  ")
+	if err != nil {
+		printErrf("Cannot serialize diffs: %v", err)
+		return ""
+	}
+	return string(data) + "\n"
+}
+
+func (m model) lineDiffs(prev, cur string) []diffmatchpatch.Diff {
+	ti1, ti2, linesIdx := m.dmp.DiffLinesToChars(prev, cur)
+	diffChars := m.dmp.DiffMain(ti1, ti2, true)
+	return m.dmp.DiffCharsToLines(diffChars, linesIdx)
+}
+
+func (m model) charDiffs(prev, cur string) []diffmatchpatch.Diff {
+	diffs := m.dmp.DiffMain(prev, cur, true)
+	switch m.cleanup {
+	case cleanupEfficiency:
+		return m.dmp.DiffCleanupEfficiency(diffs)
+	case cleanupNone:
+		return diffs
+	default:
+		return m.dmp.DiffCleanupSemanticLossless(diffs)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// outputGroup tallies the runs which produced the same output.
+type outputGroup struct {
+	// Sequential number of the output, in order of first appearance
+	id    int
+	count int
+	// Latest history entry with this output, and when it was recorded
+	last entryID
+	t    time.Time
+}
+
+func hashOutput(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// countOutput records that a run produced s, which is stored in the history entry id.
+func (m *model) countOutput(s string, id entryID) {
+	h := hashOutput(s)
+	g, ok := m.counts[h]
+	if !ok {
+		g = &outputGroup{id: len(m.counts) + 1, count: 0, last: id, t: m.hist[id].t}
+		m.counts[h] = g
+	}
+	g.count++
+	g.last = id
+	g.t = m.hist[id].t
+}
+
+// flapsTo returns the group of s if it was seen before, other than as the
+// latest output, meaning that the output flapped back to an earlier state.
+func (m model) flapsTo(s string) *outputGroup {
+	g, ok := m.counts[hashOutput(s)]
+	if !ok || m.prevID == nil || g.last == *m.prevID {
+		return nil
+	}
+	return g
+}
+
+type groupItem struct {
+	outputGroup
+	title string
+}
+
+func (i groupItem) Title() string       { return i.title }
+func (i groupItem) FilterValue() string { return i.title }
+func (i groupItem) Description() string {
+	return fmt.Sprintf("state=%d seen=%d last=%s", i.id, i.count, i.t.Format(time.TimeOnly))
+}
+
+// groupItems lists the distinct outputs, most frequent first.
+func (m model) groupItems() []list.Item {
+	groups := make([]outputGroup, 0, len(m.counts))
+	for _, g := range m.counts {
+		groups = append(groups, *g)
+	}
+	slices.SortFunc(groups, func(a, b outputGroup) int {
+		if c := cmp.Compare(b.count, a.count); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.last, a.last)
+	})
+	items := make([]list.Item, 0, len(groups))
+	for _, g := range groups {
+		title := strings.ReplaceAll(strings.TrimSpace(m.hist[g.last].plain), "\n", " ⏎ ")
+		if len(title) == 0 {
+			title = "(empty output)"
+		}
+		items = append(items, groupItem{outputGroup: g, title: title})
+	}
+	return items
+}
+
+// selectGroup shows the latest history entry of the selected output group.
+func (m *model) selectGroup() tea.Cmd {
+	gi, ok := m.groups.SelectedItem().(groupItem)
+	if !ok {
+		return nil
+	}
+	m.list.ResetFilter()
+	for i, item := range m.list.Items() {
+		if li, ok := item.(listItem); ok && li.id == gi.last {
+			m.list.Select(i)
+			break
+		}
+	}
+	m.follow = false
+	return m.switchContent()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Placeholders available in the header format
+var headerPlaceholders = []string{"cmd", "interval", "next", "iter", "host", "time"}
+
+// Placeholders available in the separator between runs in append mode
+var separatorPlaceholders = []string{"time", "exit", "iter"}
+
+// validateFormat checks that f is well formed and only uses the given placeholders.
+func validateFormat(f string, placeholders []string) error {
+	for rest := f; len(rest) > 0; {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			i = len(rest)
+		}
+		if strings.ContainsRune(rest[:i], '}') {
+			return errors.New("unmatched '}'")
+		}
+		if i == len(rest) {
+			break
+		}
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			return errors.New("unclosed '{'")
+		}
+		if name := rest[i+1 : i+j]; !slices.Contains(placeholders, name) {
+			return fmt.Errorf("unknown placeholder %q (want one of %s)", name, strings.Join(placeholders, ", "))
+		}
+		rest = rest[i+j+1:]
+	}
+	return nil
+}
+
+func (m model) renderHeaderFormat() string {
+	return strings.NewReplacer(
+		"{cmd}", strings.Join(m.cmd, " "),
+		"{interval}", m.interval.String(),
+		"{next}", m.nextView(),
+		"{iter}", fmt.Sprint(m.runs),
+		"{host}", m.host,
+		"{time}", time.Now().Format(time.TimeOnly),
+	).Replace(m.hdrFmt)
+}
+
+// runSeparator renders the line preceding the output of a run in append mode.
+func runSeparator(f string, t time.Time, rc, iter int) string {
+	return pagerSepStyle.Render(strings.NewReplacer(
+		"{time}", t.Format(time.TimeOnly),
+		"{exit}", fmt.Sprint(rc),
+		"{iter}", fmt.Sprint(iter),
+	).Replace(f))
+}
+
+// Formats of the time left before the next run
+const (
+	timerDefault = "default"
+	timerSeconds = "seconds"
+	timerClock   = "clock"
+	timerCompact = "compact"
+)
+
+func validateTimerFormat(f string) error {
+	switch f {
+	case timerDefault, timerSeconds, timerClock, timerCompact:
+		return nil
+	default:
+		return fmt.Errorf("invalid timer format %q (want %s, %s, %s or %s)",
+			f, timerDefault, timerSeconds, timerClock, timerCompact)
+	}
+}
+
+// formatTimer renders the time left d according to the timer format f.
+func formatTimer(d time.Duration, f string) string {
+	secs := int64((d + time.Second - 1) / time.Second)
+	switch f {
+	case timerSeconds:
+		return fmt.Sprintf("%ds", secs)
+	case timerClock:
+		if secs >= 3600 {
+			return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+		}
+		return fmt.Sprintf("%02d:%02d", secs/60, secs%60)
+	case timerCompact:
+		switch {
+		case secs >= 3600:
+			return fmt.Sprintf("%dh", (secs+1799)/3600)
+		case secs >= 60:
+			return fmt.Sprintf("%dm", (secs+29)/60)
+		default:
+			return fmt.Sprintf("%ds", secs)
+		}
+	default:
+		return d.String()
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Patterns to highlight in every output, see --highlight
+var highlightRes []*regexp.Regexp
+
+// Styles of the highlighted patterns, in turn
+var highlightStyles = []lipgloss.Style{
+	lipgloss.NewStyle().Foreground(lipgloss.Color("0")).Background(lipgloss.Color("11")),
+	lipgloss.NewStyle().Foreground(lipgloss.Color("0")).Background(lipgloss.Color("14")),
+	lipgloss.NewStyle().Foreground(lipgloss.Color("0")).Background(lipgloss.Color("13")),
+	lipgloss.NewStyle().Foreground(lipgloss.Color("0")).Background(lipgloss.Color("10")),
+}
+
+// highlightText highlights the matches of the patterns in the plain text s,
+// each pattern in its own color. Where matches overlap the earliest wins.
+func highlightText(s string) string {
+	if len(highlightRes) == 0 {
+		return s
+	}
+	var sb strings.Builder
+	for line := range strings.Lines(s) {
+		line, nl := strings.CutSuffix(line, "\n")
+		sb.WriteString(highlightLine(line, plainStyle))
+		if nl {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// plainStyle leaves text as it is, tabs included.
+var plainStyle = lipgloss.NewStyle().TabWidth(lipgloss.NoTabConversion)
+
+// highlightLine highlights the matches in line, rendering it with base. The
+// matches are rendered on their own, so base applies to each of them too.
+func highlightLine(line string, base lipgloss.Style) string {
+	type span struct {
+		start, end int
+		sty        lipgloss.Style
+	}
+	var spans []span
+	for i, re := range highlightRes {
+		for _, loc := range re.FindAllStringIndex(line, -1) {
+			if loc[0] < loc[1] {
+				spans = append(spans, span{start: loc[0], end: loc[1], sty: highlightStyles[i%len(highlightStyles)]})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return base.Render(line)
+	}
+	slices.SortStableFunc(spans, func(a, b span) int { return a.start - b.start })
+	var (
+		sb   strings.Builder
+		last int
+	)
+	plain := func(s string) {
+		if len(s) > 0 {
+			sb.WriteString(base.Render(s))
+		}
+	}
+	for _, sp := range spans {
+		if sp.start < last {
+			continue
+		}
+		plain(line[last:sp.start])
+		sb.WriteString(sp.sty.Inherit(base).Render(line[sp.start:sp.end]))
+		last = sp.end
+	}
+	plain(line[last:])
+	return sb.String()
+}
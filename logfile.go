@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// logWriter writes the logs to the file given with --log. Once a write fails,
+// the logs that follow are dropped, so that the watch goes on, and the first
+// error is reported.
+type logWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+	// Reports the first write error
+	onErr func(error)
+}
+
+// The logs written to the file given with --log, if any
+var logW *logWriter
+
+func newLogWriter(w io.Writer) *logWriter {
+	return &logWriter{mu: sync.Mutex{}, w: w, err: nil, onErr: nil}
+}
+
+func (lw *logWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.err != nil {
+		return len(p), nil
+	}
+	if _, err := lw.w.Write(p); err != nil {
+		lw.err = err
+		if lw.onErr != nil {
+			lw.onErr(err)
+		}
+	}
+	return len(p), nil
+}
+
+// notify makes f report the first write error, right away if there was one.
+func (lw *logWriter) notify(f func(error)) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.onErr = f
+	if lw.err != nil {
+		f(lw.err)
+	}
+}
+
+// logErrMsg tells the TUI that the logs can no longer be written.
+type logErrMsg struct{ err error }
+
+// notifyProgram reports the log write errors to the TUI of p.
+func (lw *logWriter) notifyProgram(p *tea.Program) {
+	lw.notify(func(err error) {
+		// Logs are written while handling messages, which Send would wait for
+		go p.Send(logErrMsg{err})
+	})
+}
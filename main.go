@@ -4,33 +4,111 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"math/rand/v2"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/timer"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/term"
+	"github.com/muesli/termenv"
 	"github.com/sergi/go-diff/diffmatchpatch"
 	flag "github.com/spf13/pflag"
 )
 
 var (
-	flagInterval = flag.DurationP("interval", "n", 2*time.Second, "time to wait between updates")
+	flagInterval = secondsP("interval", "n", 2*time.Second, "time to wait between updates, in seconds unless a unit is given")
+	flagMinChg   = flag.Int("min-change", 0, "only record changes of more than this many characters")
+	flagDebounce = flag.Duration("debounce", 0, "only record a change once the output stayed the same for this long")
+	flagJitter   = flag.Float64("jitter", 0, "randomize each wait by up to this percentage of the interval")
+	flagFor      = flag.Duration("for", 0, "exit after watching for this long")
 	flagErrExit  = flag.BoolP("errexit", "e", false, "exit if command has a non-zero exit")
 	flagChgExit  = flag.BoolP("chgexit", "g", false, "exit when the output of command changes")
-	flagClassic  = flag.Bool("no-tui", false, "do not use the TUI")
-	flagNoAlt    = flag.Bool("no-alt", false, "do not start the TUI in alt screen")
+	flagQuiet    = flag.BoolP("quiet", "q", false, "do not explain why --errexit or --chgexit exited")
+	flagChgCode  = flag.Int("chgexit-code", exitChanged, "exit code to use when the output of command changes")
+	flagSignif   = flag.Int("significant", 10, "Levenshtein distance above which a change is significant, see the S key")
+	flagFltFollw = flag.String("follow-filter", followTrack, "how to follow while the list is filtered: track the newest match or pause")
+	flagSticky   = flag.Bool("sticky-diff-mode", false, "remember the diff mode of each entry")
+	flagCleanup  = flag.String("diff-cleanup", cleanupSemantic, "how to clean up char diffs: semantic, efficiency or none")
+	flagEditCost = flag.Int("diff-edit-cost", 4, "cost of an edit for the efficiency cleanup of char diffs")
+	flagCntLines = flag.Bool("count-lines", false, "count the added and removed lines of line diffs, not their segments (shown with an L)")
+	flagMarkers  = flag.Bool("diff-markers", false, "enclose the changes of char diffs in {+ +} and [- -], besides coloring them")
+	flagDiffTO   = flag.Duration("diff-timeout", time.Second, "stop refining a diff after this long, which makes it faster but less optimal (0 for no timeout)")
+	flagAppend   = flag.Bool("append", false, "accumulate the output of every run instead of replacing it")
+	flagSepFmt   = flag.String("append-separator", "── {time} (exit {exit}) ──", "line between runs in append mode, using {time}, {exit} and {iter}")
+	flagCmdFile  = flag.String("command-file", "", "run the shell script in file as the command")
+	flagWaitFile = flag.String("until-file", "", "instead of running a command, wait until this file exists")
+	flagWaitPort = flag.String("until-port", "", "instead of running a command, wait until this host:port accepts connections")
+	flagNoLookup = flag.Bool("no-lookup", false, "do not check that the command exists before watching it")
+	flagTTY      = flag.Bool("tty", false, "run the command in a pseudo-terminal")
+	flagBinary   = flag.String("binary", binaryAuto, "how to show binary output: auto, hex or raw")
+	flagInclude  = flag.StringArray("include", nil, "only keep output lines matching regex (repeatable)")
+	flagExclude  = flag.StringArray("exclude", nil, "drop output lines matching regex (repeatable)")
+	flagHilite   = flag.StringArray("highlight", nil, "highlight the matches of regex in the output, in a color of its own (repeatable)")
+	flagTemplate = flag.String("transform", "", "Go template to transform JSON output with")
+	flagJSON     = flag.Bool("json", false, "pretty-print JSON output with sorted keys")
+	flagSort     = flag.Bool("sort-lines", false, "sort the output lines before comparing")
+	flagUnique   = flag.Bool("unique-lines", false, "drop repeated output lines before comparing")
+	flagRetries  = flag.Int("retries", 0, "retry a failing command up to this many times")
+	flagRetryDly = flag.Duration("retry-delay", time.Second, "time to wait between retries")
+	flagHdrFmt   = flag.String("header-format", "", "header template using {cmd}, {interval}, {next}, {iter}, {host} and {time}")
+	flagShowHost = flag.Bool("show-host", false, "show the hostname and the command PID in the header")
+	flagFlash    = flag.Bool("flash", false, "flash the screen when the output changes")
+	flagFlashClr = flag.String("flash-color", "219", "color to flash the screen with")
+	flagThrottle = flag.Duration("notify-throttle", 0, "flash at most once in this long, then once for the changes held back")
+	flagNoProg   = flag.Bool("no-progress", false, "show the time to the next run as text only")
+	flagTmrFmt   = flag.String("timer-format", timerDefault, "how to show the time to the next run: default, seconds, clock or compact")
+	flagListCols = flag.StringSlice("list-columns", []string{"chars", "lines", "lev", "add", "del", "cpu", "rss"}, "fields describing history entries: time, chars, lines, lev, add, del, cpu, rss, exit or dur")
+	flagDiffAdd  = flag.String("diff-add-color", "2", "color of the insertions in diffs")
+	flagDiffDel  = flag.String("diff-del-color", "1", "color of the deletions in diffs")
+	flagBg       = flag.String("background", bgAuto, "background of the terminal to pick colors for: auto, dark or light")
+	flagRecord   = flag.String("record", "", "record the TUI to an asciinema cast file")
+	flagReplay   = flag.String("replay", "", "replay a session saved with --save instead of running a command")
+	flagSpeed    = flag.Float64("replay-speed", 1, "speed factor of the replay")
+	flagRplTail  = flag.Int("replay-tail", 0, "replay only the last this many entries of the session (0 for all)")
+	flagClear    = flag.String("clear", "", "how to clear the screen between runs without the TUI: ansi, form-feed or none (default ansi on a terminal, none otherwise)")
+	flagClassic  = flag.Bool("no-tui", false, "do not use the TUI (the default when stdout is not a terminal)")
+	flagForceTUI = flag.Bool("force-tui", false, "use the TUI even if stdout is not a terminal")
+	flagSplit    = flag.Bool("split", false, "watch two commands separated by -- side by side")
+	flagTabs     = flag.Bool("tabs", false, "watch the commands separated by -- in tabs")
+	flagParallel = flag.Int("concurrency", 0, "run at most this many of the watched commands at once (0 for no limit)")
+	flagNoAlt    = flag.Bool("no-alt", false, "do not start the TUI in alt screen, overriding $"+envAlt)
+	flagInline   = flag.Int("inline-height", 20, "lines taken by the TUI outside of the alt screen (0 for the whole terminal)")
+	flagPagerH   = flag.Int("pager-height", 0, "most lines taken by the pager, keeping the status bar close to the output (0 for all those left)")
+	flagAutoStop = flag.Bool("auto-pause", false, "pause while the terminal is unfocused")
+	flagConfirm  = flag.Bool("confirm-quit", false, "ask for confirmation before quitting")
+	flagPager    = flag.String("pager", "", "command to page the selected output with (default $PAGER)")
+	flagOutFile  = flag.String("output-file", "", "write the output to file on exit")
+	flagSave     = flag.String("save", "", "save the session history to file on exit")
+	flagCSV      = flag.String("csv", "", "write the metrics of each output to CSV file on exit (TUI only)")
+	flagChart    = flag.String("chart", "", "plot the size of each output to SVG file on exit (TUI only)")
+	flagMetrics  = flag.String("metrics-file", "", "write Prometheus metrics to file after every run")
+	flagHTTP     = flag.String("http", "", "serve Prometheus metrics and a health check on address")
+	flagControl  = flag.String("control", "", "accept commands on Unix socket (TUI only)")
+	flagServe    = flag.String("serve", "", "serve a page with the latest output on address (default host localhost)")
+	flagOutWhich = flag.String("output-which", outputNewest, "which output to write on exit: newest or selected")
+	flagOutFmt   = flag.String("output-format", formatText, "how to write the output on exit: text, or diffjson for the line and char diffs as JSON (TUI only)")
 	flagLog      = flag.String("log", "", "write debug logs to file")
 	flagDebug    = flag.Bool("debug", false, "enable tracing logs")
 	flagHelp     = flag.BoolP("help", "h", false, "display this help and exit")
+	flagNoBanner = flag.Bool("no-banner", false, "do not show the banner in this help")
 	flagVersion  = flag.BoolP("version", "V", false, "show binary version")
 )
 
@@ -45,13 +123,14 @@ var (
 )
 
 var (
-	colorDark   = lipgloss.Color("55")
-	colorBlue   = lipgloss.Color("19")
-	colorViolet = lipgloss.Color("135")
-	colorPurple = lipgloss.Color("141")
-	colorPink   = lipgloss.Color("219")
-	colorLight  = lipgloss.Color("225")
-	colorErr    = lipgloss.Color("162")
+	// Each color has a variant which stays legible on light backgrounds
+	colorDark   = lipgloss.AdaptiveColor{Light: "189", Dark: "55"}
+	colorBlue   = lipgloss.AdaptiveColor{Light: "25", Dark: "19"}
+	colorViolet = lipgloss.AdaptiveColor{Light: "91", Dark: "135"}
+	colorPurple = lipgloss.AdaptiveColor{Light: "97", Dark: "141"}
+	colorPink   = lipgloss.AdaptiveColor{Light: "125", Dark: "219"}
+	colorLight  = lipgloss.AdaptiveColor{Light: "55", Dark: "225"}
+	colorErr    = lipgloss.AdaptiveColor{Light: "160", Dark: "162"}
 
 	headerStyle = lipgloss.NewStyle().
 			Background(colorDark).
@@ -62,7 +141,10 @@ var (
 			Foreground(colorPink).
 			Padding(1, 0, 0, 0).
 			Align(lipgloss.Center)
-	pagerStyle = lipgloss.NewStyle().
+	pagerStatsStyle = lipgloss.NewStyle().Foreground(colorPurple)
+	pagerEmptyStyle = lipgloss.NewStyle().Foreground(colorViolet).Italic(true)
+	pagerSepStyle   = lipgloss.NewStyle().Foreground(colorViolet).Faint(true)
+	pagerStyle      = lipgloss.NewStyle().
 			Border(lipgloss.InnerHalfBlockBorder(), true, false).
 			BorderForeground(colorBlue)
 
@@ -85,12 +167,50 @@ var (
 	helpKeyStyle  = lipgloss.NewStyle().Foreground(colorPink).Bold(true)
 	helpDescStyle = lipgloss.NewStyle().Foreground(colorPurple)
 
+	keysHelpStyle      = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(colorViolet).Padding(1, 2)
+	keysHelpTitleStyle = lipgloss.NewStyle().Foreground(colorPink).Underline(true)
+
+	toastStyle = lipgloss.NewStyle().Foreground(colorLight).Background(colorViolet).Padding(0, 1)
+
 	errStyle = lipgloss.NewStyle().Foreground(colorErr).Padding(1)
+
+	listItemFailStyle = lipgloss.NewStyle().Foreground(colorErr).Bold(true)
+
+	diffInsStyle = lipgloss.NewStyle().TabWidth(lipgloss.NoTabConversion)
+	diffDelStyle = lipgloss.NewStyle().TabWidth(lipgloss.NoTabConversion)
+	diffDimStyle = lipgloss.NewStyle().TabWidth(lipgloss.NoTabConversion).Faint(true)
+)
+
+const (
+	errTxtExit  = "Watched program exit with non-zero exit status"
+	errTxtChg   = "Watched program output changed"
+	errTxtUntil = "Waited condition holds"
+)
+
+const (
+	outputNewest   = "newest"
+	outputSelected = "selected"
+)
+
+// Formats of the output written on exit
+const (
+	formatText = "text"
+	// The diff operations, for scripts to post-process
+	formatDiffJSON = "diffjson"
+)
+
+// How to follow while the list is filtered
+const (
+	followTrack = "track"
+	followPause = "pause"
 )
 
+// Exit codes, other than the ones of the watched command
 const (
-	errTxtExit = "Watched program exit with non-zero exit status"
-	errTxtChg  = "Watched program output changed"
+	// Failed to run the command
+	exitFailure = 1
+	// Output of the command changed (see --chgexit)
+	exitChanged = 2
 )
 
 type focussedView uint
@@ -98,6 +218,7 @@ type focussedView uint
 const (
 	focussedPager focussedView = iota
 	focussedList
+	focussedGroups
 )
 
 // Disable logging
@@ -105,36 +226,130 @@ const LevelNoLogs = slog.LevelError + 1
 
 type model struct {
 	interval time.Duration
-	errExit  bool
-	chgExit  bool
+	jitter   float64
+	signif   int
+	limit    time.Duration
 	alt      bool
+	inline   int
+	pagerH   int
+	autoStop bool
+	confirm  bool
+	sticky   bool
+	cleanup  string
+	markers  bool
+	cntLines bool
+	fltFollw string
+	accum    bool
+	sepFmt   string
+	pagerCmd string
+	hdrFmt   string
+	tmrFmt   string
+	iview    *itemView
+	host     string
+	showHost bool
+	flash    bool
+	flashClr lipgloss.Color
+	throttle time.Duration
+	progress bool
+	runner   runner
+	replay   *replay
+	rec      *recorder
 	cmd      []string
 
 	width  int
 	height int
 
-	// Whether to show line-level diff or not
-	lineDiff bool
+	// How to show the difference from the previous output
+	diff diffMode
 	// Whether to follow the latest output
 	follow bool
+	// Whether to follow from before the list was filtered, if it is
+	fltSnap *bool
 	// Whether to paused the command loop
 	paused bool
+	// Whether the loop was paused because the terminal lost focus
+	blurred bool
+	// Whether the command is running
+	busy bool
+	// Whether the latest run failed
+	failed bool
+	// Number of completed runs, and of the ones whose output changed
+	runs    int
+	changes int
+	// When the watch started
+	start time.Time
+	// PID of the last command run
+	pid int
+	// Time to wait before the next run, and the fraction of it elapsed
+	wait    time.Duration
+	elapsed float64
+	// Whether the screen is flashing, and which flash is the latest
+	flashing bool
+	flashID  int
+	// Feedback on the last action, and which toast is the latest
+	toastMsg string
+	toastID  int
+	// Whether the logs could not be written, and are dropped
+	logFail bool
+	// When a change was last notified, and how many were held back since
+	notified time.Time
+	held     int
 	// Which view is visible / focussed
 	focus focussedView
+	// Which output stream is displayed
+	stream outStream
+	// Whether to show the output as it was before filtering
+	raw bool
+	// How the unchanged lines of line diffs are shown
+	unchg unchangedMode
+	// What entries are compared with, and the entry pinned as baseline
+	anchor diffAnchor
+	baseID *entryID
+	// How many times each distinct output was seen
+	counts map[uint64]*outputGroup
+	// How many times the output flapped back to an earlier state
+	flaps int
+	// Filter of the history list
+	lfilter *listFilter
+	// Whether the prompt is shown, and the last error from it. The prompt
+	// reads either a metric filter or, when searching, a search query.
+	prompting bool
+	searching bool
+	promptErr string
+	// Text highlighted in the pager, and how many times it occurs there
+	query   string
+	matches int
+	// What the pager shows, before highlighting
+	content string
+	// Lines of the pager where the changes of the diff shown start
+	hunks []int
+	// Whether the quit confirmation is shown
+	quitting bool
+	// Whether the overlay listing every key binding is shown
+	keysHelp bool
+	// Exit code to quit the program with
+	rc int
+	// Output of all the runs, in append mode
+	acc [numStreams]string
 	// Command output history
-	hist map[time.Time]*historyEntry
-	// Time at which we received the last command output
-	prevT *time.Time
+	hist map[entryID]*historyEntry
+	// ID of the last history entry recorded
+	lastID entryID
+	// Entry of the last command output
+	prevID *entryID
 	// Which command output is selected and displayed
-	seleT *time.Time
+	seleID *entryID
 
 	dmp *diffmatchpatch.DiffMatchPatch
 
-	keys  keyMap
-	help  help.Model
-	timer timer.Model
-	pager viewport.Model
-	list  list.Model
+	keys   keyMap
+	help   help.Model
+	timer  timer.Model
+	pager  viewport.Model
+	list   list.Model
+	groups list.Model
+	prompt textinput.Model
+	bar    progress.Model
 }
 
 type keyMap struct {
@@ -146,6 +361,26 @@ type keyMap struct {
 	diffMode          key.Binding
 	toggleFollow      key.Binding
 	togglePause       key.Binding
+	openPager         key.Binding
+	openEditor        key.Binding
+	switchStream      key.Binding
+	toggleGroups      key.Binding
+	metricFilter      key.Binding
+	toggleRaw         key.Binding
+	goLive            key.Binding
+	stepReplay        key.Binding
+	toggleDeltas      key.Binding
+	search            key.Binding
+	nextChange        key.Binding
+	prevChange        key.Binding
+	unchanged         key.Binding
+	diffAnchor        key.Binding
+	pinBase           key.Binding
+	significant       key.Binding
+	toggleErrExit     key.Binding
+	toggleChgExit     key.Binding
+	copyPatch         key.Binding
+	resetTimer        key.Binding
 }
 
 const (
@@ -154,27 +389,89 @@ const (
 	switchFocusDescPager = "list"
 )
 
-func newModel(cmd []string) model {
+func newModel(cmd []string, pipeline outputPipeline) model {
 	listDelegate := list.NewDefaultDelegate()
 	listDelegate.Styles.SelectedTitle = listItemTitleStyle
 	listDelegate.Styles.SelectedDesc = listItemDescStyle
 
 	m := model{
-		interval: *flagInterval,
-		errExit:  *flagErrExit,
-		chgExit:  *flagChgExit,
-		alt:      !*flagNoAlt,
-		width:    0,
-		height:   0,
-		lineDiff: true,
-		follow:   true,
-		paused:   false,
-		focus:    focussedPager,
-		cmd:      cmd,
-		dmp:      diffmatchpatch.New(),
-		hist:     make(map[time.Time]*historyEntry),
-		prevT:    nil,
-		seleT:    nil,
+		interval:  *flagInterval,
+		jitter:    *flagJitter,
+		signif:    *flagSignif,
+		limit:     *flagFor,
+		alt:       !*flagNoAlt,
+		inline:    *flagInline,
+		pagerH:    *flagPagerH,
+		autoStop:  *flagAutoStop,
+		confirm:   *flagConfirm,
+		sticky:    *flagSticky,
+		cleanup:   *flagCleanup,
+		markers:   *flagMarkers,
+		cntLines:  *flagCntLines,
+		fltFollw:  *flagFltFollw,
+		accum:     *flagAppend,
+		sepFmt:    *flagSepFmt,
+		runner:    newRunner(cmd, pipeline),
+		replay:    nil,
+		rec:       nil,
+		pagerCmd:  pagerCommand(),
+		hdrFmt:    *flagHdrFmt,
+		tmrFmt:    *flagTmrFmt,
+		iview:     &itemView{cols: *flagListCols, deltas: false},
+		host:      hostname(),
+		showHost:  *flagShowHost,
+		flash:     *flagFlash,
+		flashClr:  lipgloss.Color(*flagFlashClr),
+		throttle:  *flagThrottle,
+		progress:  !*flagNoProg,
+		width:     0,
+		height:    0,
+		diff:      diffLine,
+		follow:    true,
+		fltSnap:   nil,
+		paused:    false,
+		blurred:   false,
+		busy:      true,
+		failed:    false,
+		runs:      0,
+		changes:   0,
+		start:     time.Now(),
+		pid:       0,
+		wait:      0,
+		elapsed:   0,
+		flashing:  false,
+		flashID:   0,
+		toastMsg:  "",
+		toastID:   0,
+		logFail:   false,
+		notified:  time.Time{},
+		held:      0,
+		focus:     focussedPager,
+		stream:    streamOut,
+		raw:       false,
+		unchg:     unchangedShow,
+		anchor:    anchorPrev,
+		baseID:    nil,
+		counts:    make(map[uint64]*outputGroup),
+		flaps:     0,
+		lfilter:   &listFilter{metric: nil},
+		prompting: false,
+		searching: false,
+		promptErr: "",
+		query:     "",
+		matches:   0,
+		content:   "",
+		hunks:     nil,
+		quitting:  false,
+		keysHelp:  false,
+		rc:        0,
+		cmd:       cmd,
+		dmp:       newDiffMatchPatch(),
+		acc:       [numStreams]string{},
+		hist:      make(map[entryID]*historyEntry),
+		lastID:    0,
+		prevID:    nil,
+		seleID:    nil,
 		keys: keyMap{
 			toggleAltScreen: key.NewBinding(
 				key.WithKeys("a"),
@@ -209,11 +506,95 @@ func newModel(cmd []string) model {
 				key.WithKeys("p"),
 				key.WithHelp("p", "toggle pause"),
 			),
+			openPager: key.NewBinding(
+				key.WithKeys("o"),
+				key.WithHelp("o", "open in pager"),
+			),
+			openEditor: key.NewBinding(
+				key.WithKeys("e"),
+				key.WithHelp("e", "open in editor"),
+			),
+			switchStream: key.NewBinding(
+				key.WithKeys("s"),
+				key.WithHelp("s", "switch stream"),
+			),
+			toggleGroups: key.NewBinding(
+				key.WithKeys("c"),
+				key.WithHelp("c", "output groups"),
+			),
+			metricFilter: key.NewBinding(
+				key.WithKeys("M"),
+				key.WithHelp("M", "metric filter"),
+			),
+			toggleRaw: key.NewBinding(
+				key.WithKeys("R"),
+				key.WithHelp("R", "toggle raw output"),
+			),
+			goLive: key.NewBinding(
+				key.WithKeys("L"),
+				key.WithHelp("L", "back to live"),
+			),
+			stepReplay: key.NewBinding(
+				key.WithKeys("n"),
+				key.WithHelp("n", "next replay entry"),
+				key.WithDisabled(),
+			),
+			toggleDeltas: key.NewBinding(
+				key.WithKeys("D"),
+				key.WithHelp("D", "toggle deltas"),
+			),
+			search: key.NewBinding(
+				key.WithKeys("F"),
+				key.WithHelp("F", "search output"),
+			),
+			nextChange: key.NewBinding(
+				key.WithKeys("]"),
+				key.WithHelp("]", "next change"),
+			),
+			prevChange: key.NewBinding(
+				key.WithKeys("["),
+				key.WithHelp("[", "previous change"),
+			),
+			unchanged: key.NewBinding(
+				key.WithKeys("U"),
+				key.WithHelp("U", "dim/fold unchanged lines"),
+			),
+			diffAnchor: key.NewBinding(
+				key.WithKeys("A"),
+				key.WithHelp("A", "switch diff anchor"),
+			),
+			pinBase: key.NewBinding(
+				key.WithKeys("P"),
+				key.WithHelp("P", "pin as baseline"),
+			),
+			significant: key.NewBinding(
+				key.WithKeys("S"),
+				key.WithHelp("S", "significant changes only"),
+			),
+			toggleErrExit: key.NewBinding(
+				key.WithKeys("E"),
+				key.WithHelp("E", "toggle exit on error"),
+			),
+			toggleChgExit: key.NewBinding(
+				key.WithKeys("C"),
+				key.WithHelp("C", "toggle exit on change"),
+			),
+			copyPatch: key.NewBinding(
+				key.WithKeys("y"),
+				key.WithHelp("y", "copy diff as patch"),
+			),
+			resetTimer: key.NewBinding(
+				key.WithKeys("r"),
+				key.WithHelp("r", "restart countdown"),
+			),
 		},
-		help:  help.New(),
-		timer: timer.Model{}, //nolint:exhaustruct // We don't use it before re-creating it
-		pager: viewport.New(0, 0),
-		list:  list.New([]list.Item{}, listDelegate, 0, 0),
+		help:   help.New(),
+		timer:  timer.Model{}, //nolint:exhaustruct // We don't use it before re-creating it
+		pager:  viewport.New(0, 0),
+		list:   list.New([]list.Item{}, listDelegate, 0, 0),
+		groups: list.New([]list.Item{}, listDelegate, 0, 0),
+		prompt: textinput.New(),
+		bar:    progress.New(progress.WithWidth(progressWidth), progress.WithoutPercentage()),
 	}
 
 	m.help.Styles.ShortKey = helpKeyStyle
@@ -225,7 +606,7 @@ func newModel(cmd []string) model {
 	m.list.SetShowStatusBar(false)
 	m.list.SetShowHelp(false)
 	m.list.InfiniteScrolling = false
-	m.list.Filter = list.UnsortedFilter
+	m.list.Filter = m.lfilter.filter
 	m.list.KeyMap = list.KeyMap{
 		CursorUp: key.NewBinding(
 			key.WithKeys("up", "k"),
@@ -269,7 +650,7 @@ func newModel(cmd []string) model {
 		),
 		ShowFullHelp: key.NewBinding(
 			key.WithKeys("?"),
-			key.WithHelp("?", "more"),
+			key.WithHelp("?", "all keys"),
 		),
 		CloseFullHelp: key.NewBinding(
 			key.WithKeys("?"),
@@ -282,6 +663,12 @@ func newModel(cmd []string) model {
 		ForceQuit: key.NewBinding(key.WithKeys("ctrl+c")),
 	}
 
+	m.groups.SetShowTitle(false)
+	m.groups.SetShowStatusBar(false)
+	m.groups.SetShowHelp(false)
+	m.groups.InfiniteScrolling = false
+	m.groups.KeyMap = m.list.KeyMap
+
 	m.pager.Style = pagerStyle
 	m.pager.KeyMap = viewport.KeyMap{
 		Up: key.NewBinding(
@@ -315,17 +702,136 @@ func newModel(cmd []string) model {
 	return m
 }
 
+// entryID identifies a history entry. IDs are sequential, so that entries
+// recorded in the same instant stay distinct.
+type entryID int
+
 type historyEntry struct {
-	plain        string
-	diffC, diffL *string
-	prevT        *time.Time
+	plain, stderr, combined string
+	// When the output was recorded
+	t time.Time
+	// Rendered diffs, for each output stream
+	diffC, diffL [numStreams]*renderedDiff
+	prevID       *entryID
+	// Diff mode last used to display this entry (only with sticky diff mode)
+	diff *diffMode
+	// Output before filtering, if it was filtered
+	raw *[numStreams]string
+	// Why the run failed, if it did
+	err string
+}
+
+func newHistoryEntry(t time.Time, plain, stderr, combined string, prevID *entryID) *historyEntry {
+	return &historyEntry{
+		plain: plain, stderr: stderr, combined: combined, t: t, prevID: prevID,
+		diffC: [numStreams]*renderedDiff{}, diffL: [numStreams]*renderedDiff{}, diff: nil, raw: nil, err: "",
+	}
+}
+
+func (h *historyEntry) rawText(s outStream) string {
+	if h.raw == nil {
+		return h.text(s)
+	}
+	return h.raw[s]
+}
+
+func (h *historyEntry) text(s outStream) string {
+	switch s {
+	case streamErr:
+		return h.stderr
+	case streamBoth:
+		return h.combined
+	default:
+		return h.plain
+	}
+}
+
+type outStream uint
+
+const (
+	streamOut outStream = iota
+	streamErr
+	streamBoth
+	numStreams
+)
+
+func (s outStream) String() string {
+	switch s {
+	case streamErr:
+		return "err"
+	case streamBoth:
+		return "both"
+	default:
+		return "out"
+	}
+}
+
+// diffMode is how an output is shown compared to the previous one.
+type diffMode uint
+
+const (
+	diffLine diffMode = iota
+	diffChar
+	// Only the output, without diff
+	diffOff
+	// The previous output above the current one
+	diffStacked
+	numDiffModes
+)
+
+func (d diffMode) String() string {
+	switch d {
+	case diffChar:
+		return "char"
+	case diffOff:
+		return "off"
+	case diffStacked:
+		return "stacked"
+	default:
+		return "line"
+	}
+}
+
+// What the selected entry is compared with
+type diffAnchor uint
+
+const (
+	anchorPrev diffAnchor = iota
+	anchorFirst
+	// The entry pinned as baseline
+	anchorBase
+	anchorNewest
+	numAnchors
+)
+
+func (a diffAnchor) String() string {
+	switch a {
+	case anchorFirst:
+		return "first"
+	case anchorBase:
+		return "base"
+	case anchorNewest:
+		return "new"
+	default:
+		return "prev"
+	}
 }
 
-func newHistoryEntry(txt string, prevT *time.Time) *historyEntry {
-	return &historyEntry{plain: txt, prevT: prevT, diffC: nil, diffL: nil}
+func (a diffAnchor) describe() string {
+	switch a {
+	case anchorFirst:
+		return "first entry"
+	case anchorBase:
+		return "baseline"
+	case anchorNewest:
+		return "newest entry"
+	default:
+		return "previous entry"
+	}
 }
 
 type listItem struct {
+	id        entryID
 	t         time.Time
 	title     string
 	nChars    int
@@ -333,47 +839,181 @@ type listItem struct {
 	levDist   *int
 	additions *int
 	deletions *int
+	// Whether additions and deletions count lines rather than diff segments
+	inLines bool
+	usage   *resUsage
+	// Exit code and duration of the run
+	exit int
+	dur  time.Duration
+	// Earlier output state this flapped back to, if not zero
+	flapTo int
+	// Change in chars and lines from the previous entry, if any
+	dChars, dLines *int
+	// How to describe the item, shared by every item
+	view *itemView
+}
+
+// itemView is how list items are described.
+type itemView struct {
+	// Fields shown in the description
+	cols []string
+	// Whether chars and lines are shown as changes from the previous entry
+	deltas bool
 }
 
-func newListItem(t time.Time, chars, lines int) listItem {
+// Fields which can describe a list item
+var listColumns = []string{"time", "chars", "lines", "lev", "add", "del", "cpu", "rss", "exit", "dur"}
+
+func validateListColumns(cols []string) error {
+	if len(cols) == 0 {
+		return errors.New("no columns")
+	}
+	for _, col := range cols {
+		if !slices.Contains(listColumns, col) {
+			return fmt.Errorf("unknown column %q (want one of %s)", col, strings.Join(listColumns, ", "))
+		}
+	}
+	return nil
+}
+
+func newListItem(id entryID, t time.Time, chars, lines int, usage *resUsage, view *itemView) listItem {
 	return listItem{
-		t: t, title: t.String(), nChars: chars, nLines: lines,
-		levDist: nil, additions: nil, deletions: nil,
+		id: id, t: t, title: t.String(), nChars: chars, nLines: lines,
+		levDist: nil, additions: nil, deletions: nil, inLines: false, usage: usage, exit: 0, dur: 0, flapTo: 0,
+		dChars: nil, dLines: nil, view: view,
 	}
 }
 func (i listItem) Title() string       { return i.title }
-func (i listItem) FilterValue() string { return i.title }
+func (i listItem) FilterValue() string { return i.title + filterValueSep + i.metricsValue() }
 func (i listItem) Description() string {
-	return fmt.Sprintf("chars=%d lines=%d lev=%s +%s -%s",
-		i.nChars, i.nLines, intp2String(i.levDist), intp2String(i.additions), intp2String(i.deletions))
+	fields := make([]string, 0, len(i.view.cols))
+	for _, col := range i.view.cols {
+		fields = append(fields, i.column(col))
+	}
+	desc := strings.Join(fields, " ")
+	if i.exit != 0 {
+		desc = listItemFailStyle.Render(fmt.Sprintf("✗ exit=%d", i.exit)) + " " + desc
+	}
+	if i.flapTo > 0 {
+		desc += fmt.Sprintf(" flapped to state %d", i.flapTo)
+	}
+	return desc
+}
+
+func (i listItem) column(col string) string {
+	cpu, rss := "n/a", "n/a"
+	if i.usage != nil {
+		cpu = (i.usage.user + i.usage.sys).Round(time.Millisecond).String()
+		rss = bytes2String(int(i.usage.maxRSS))
+	}
+	switch col {
+	case "time":
+		return i.t.Format(time.TimeOnly)
+	case "chars":
+		if i.view.deltas {
+			return "chars Δ" + delta2String(i.dChars)
+		}
+		return fmt.Sprintf("chars=%d", i.nChars)
+	case "lines":
+		if i.view.deltas {
+			return "lines Δ" + delta2String(i.dLines)
+		}
+		return fmt.Sprintf("lines=%d", i.nLines)
+	case "lev":
+		return "lev=" + intp2String(i.levDist)
+	case "add":
+		return "+" + intp2String(i.additions) + i.countUnit()
+	case "del":
+		return "-" + intp2String(i.deletions) + i.countUnit()
+	case "cpu":
+		return "cpu=" + cpu
+	case "rss":
+		return "rss=" + rss
+	case "exit":
+		return fmt.Sprintf("exit=%d", i.exit)
+	case "dur":
+		return "dur=" + i.dur.Round(time.Millisecond).String()
+	default:
+		return ""
+	}
+}
+
+// countUnit marks additions and deletions counted in lines with an L.
+func (i listItem) countUnit() string {
+	if i.inLines && i.additions != nil {
+		return "L"
+	}
+	return ""
 }
 
-func (i *listItem) update(dmp *diffmatchpatch.DiffMatchPatch, diffs []diffmatchpatch.Diff) {
+// update computes the metrics of the item from its diff. Additions and
+// deletions count the diff segments, or the lines in them if inLines.
+func (i *listItem) update(dmp *diffmatchpatch.DiffMatchPatch, diffs []diffmatchpatch.Diff, inLines bool) {
 	i.levDist = new(int)
 	*i.levDist = dmp.DiffLevenshtein(diffs)
 
 	i.additions = new(int)
 	i.deletions = new(int)
+	i.inLines = inLines
 	for _, d := range diffs {
+		n := 1
+		if inLines {
+			n = strings.Count(d.Text, "\n")
+			if !strings.HasSuffix(d.Text, "\n") {
+				n++
+			}
+		}
 		switch d.Type {
 		case diffmatchpatch.DiffInsert:
-			*i.additions++
+			*i.additions += n
 		case diffmatchpatch.DiffDelete:
-			*i.deletions++
+			*i.deletions += n
 		}
 	}
 }
 
 type cmdMsg struct {
-	out []byte
+	out cmdOutput
 	err error
 }
 
+type execDoneMsg struct {
+	err error
+}
+
+type flashEndMsg struct {
+	id int
+}
+
+type toastEndMsg struct {
+	id int
+}
+
+// throttleEndMsg tells that changes can be notified again.
+type throttleEndMsg struct{}
+
+// limitMsg tells that the time limit set with --for is over.
+type limitMsg struct{}
+
 func (m model) Init() tea.Cmd {
+	if m.limit > 0 {
+		return tea.Batch(m.runCmd, tea.Tick(m.limit, func(time.Time) tea.Msg {
+			return limitMsg{}
+		}))
+	}
 	return m.runCmd
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	tm, cmd := m.handleMsg(msg)
+	if m, ok := tm.(model); ok && m.rec != nil {
+		// Recorded as the view changes, rendering it is left to the program
+		m.rec.frame(m.View(), m.width, m.height)
+	}
+	return tm, cmd
+}
+
+func (m model) handleMsg(msg tea.Msg) (tea.Model, tea.Cmd) {
 	slog.Debug("New message", "type", reflect.TypeOf(msg))
 
 	var (
@@ -388,7 +1028,18 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.height = msg.Height
 
 	case tea.KeyMsg:
-		if !m.list.SettingFilter() {
+		if m.prompting {
+			return m, m.handlePrompt(msg)
+		}
+		if m.quitting {
+			return m, m.handleQuitConfirm(msg)
+		}
+		if m.keysHelp {
+			// Any key dismisses the overlay
+			m.keysHelp = false
+			return m, nil
+		}
+		if !m.list.SettingFilter() && !m.groups.SettingFilter() {
 			cmd = m.handleKey(msg)
 			cmds = append(cmds, cmd)
 		}
@@ -400,14 +1051,90 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		cmds = append(cmds, cmd)
 
+	case list.FilterMatchesMsg:
+		// Results of filtering the history list after new entries, which
+		// must reach it even if it is not focussed. The groups list is only
+		// filtered while focussed.
+		if m.focus != focussedGroups {
+			m.list, cmd = m.list.Update(msg)
+			cmds = append(cmds, cmd, m.followFiltered())
+			return m, tea.Batch(cmds...)
+		}
+
 	case timer.TickMsg, timer.StartStopMsg:
 		m.timer, cmd = m.timer.Update(msg)
+		if m.wait > 0 {
+			m.elapsed = 1 - float64(m.timer.Timeout)/float64(m.wait)
+		}
 		cmds = append(cmds, cmd)
 
 	case timer.TimeoutMsg:
+		// Timers are replaced on every run, when the interval changes and on
+		// refresh: the timeout of a superseded one must not run the command
+		// again
+		if msg.ID != m.timer.ID() || m.busy {
+			slog.Debug("Ignoring stale timeout", "id", msg.ID, "current", m.timer.ID())
+			break
+		}
 		m.timer, cmd = m.timer.Update(msg)
+		m.busy = true
 		cmds = append(cmds, cmd, m.runCmd)
 
+	case throttleEndMsg:
+		if m.held > 0 {
+			slog.Debug("Notifying held back changes", "changes", m.held)
+			m.held = 0
+			m.notified = time.Now()
+			cmds = append(cmds, m.startFlash())
+		}
+
+	case flashEndMsg:
+		if msg.id == m.flashID {
+			m.flashing = false
+		}
+
+	case toastEndMsg:
+		if msg.id == m.toastID {
+			m.toastMsg = ""
+		}
+
+	case tea.BlurMsg:
+		if m.autoStop && !m.paused {
+			m.paused = true
+			m.blurred = true
+			cmds = append(cmds, m.timer.Stop())
+		}
+
+	case tea.FocusMsg:
+		if m.blurred {
+			m.paused = false
+			m.blurred = false
+			cmds = append(cmds, m.timer.Start())
+		}
+
+	case controlMsg:
+		cmds = append(cmds, m.handleControl(msg))
+
+	case logErrMsg:
+		m.logFail = true
+		cmds = append(cmds, m.toast(fmt.Sprintf("Cannot write logs, dropping them: %v", msg.err)))
+
+	case limitMsg:
+		slog.Info("Time limit reached", "limit", m.limit)
+		return m, tea.Quit
+
+	case copiedMsg:
+		if msg.err != nil {
+			slog.Warn("Cannot copy to the clipboard", "err", msg.err)
+		}
+		cmds = append(cmds, m.toast(msg.String()))
+
+	case execDoneMsg:
+		if msg.err != nil {
+			slog.Warn("External command failed", "err", msg.err)
+			cmds = append(cmds, m.toast(fmt.Sprintf("Failed: %v", msg.err)))
+		}
+
 	}
 
 	switch m.focus {
@@ -417,6 +1144,16 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case focussedPager:
 		m.pager, cmd = m.pager.Update(msg)
 		cmds = append(cmds, cmd)
+	case focussedGroups:
+		m.groups, cmd = m.groups.Update(msg)
+		cmds = append(cmds, cmd)
+	}
+
+	// The list stops being filtered in many ways, e.g. cancelling the filter
+	// or accepting one without matches
+	if m.fltSnap != nil && !m.filtering() {
+		cmd = m.restoreFollow()
+		cmds = append(cmds, cmd)
 	}
 
 	return m, tea.Batch(cmds...)
@@ -445,23 +1182,47 @@ func (m *model) handleKey(msg tea.KeyMsg) tea.Cmd {
 		switch m.focus {
 		case focussedList:
 			m.focus = focussedPager
-			m.keys.switchFocus.SetHelp(switchFocusKey, switchFocusDescPager)
+			m.setFocusHelp(switchFocusDescPager)
 			m.keys.listSelect.SetEnabled(false)
 			cmd = m.switchContent()
 			cmds = append(cmds, cmd)
 		case focussedPager:
 			m.focus = focussedList
-			m.keys.switchFocus.SetHelp(switchFocusKey, switchFocusDescList)
+			m.setFocusHelp(switchFocusDescList)
 			m.keys.listSelect.SetEnabled(true)
+		case focussedGroups:
+			m.focus = focussedPager
+			m.setFocusHelp(switchFocusDescPager)
+			m.keys.listSelect.SetEnabled(false)
 		}
 
 	case key.Matches(msg, m.keys.listSelect):
 		if m.focus == focussedList && !m.list.SettingFilter() {
 			m.focus = focussedPager
-			m.keys.switchFocus.SetHelp(switchFocusKey, switchFocusDescPager)
+			m.setFocusHelp(switchFocusDescPager)
 			m.keys.listSelect.SetEnabled(false)
 			cmd = m.switchContent()
 			cmds = append(cmds, cmd)
+		} else if m.focus == focussedGroups && !m.groups.SettingFilter() {
+			m.focus = focussedPager
+			m.setFocusHelp(switchFocusDescPager)
+			m.keys.listSelect.SetEnabled(false)
+			cmd = m.selectGroup()
+			cmds = append(cmds, cmd)
+		}
+
+	case key.Matches(msg, m.keys.toggleGroups):
+		if m.focus == focussedGroups {
+			m.focus = focussedPager
+			m.setFocusHelp(switchFocusDescPager)
+			m.keys.listSelect.SetEnabled(false)
+		} else {
+			cmd = m.groups.SetItems(m.groupItems())
+			cmds = append(cmds, cmd)
+			m.groups.ResetSelected()
+			m.focus = focussedGroups
+			m.setFocusHelp(switchFocusDescList)
+			m.keys.listSelect.SetEnabled(true)
 		}
 
 	case key.Matches(msg, lkm.CursorUp, lkm.CursorDown, lkm.NextPage, lkm.PrevPage):
@@ -471,13 +1232,58 @@ func (m *model) handleKey(msg tea.KeyMsg) tea.Cmd {
 
 	case key.Matches(msg, lkm.Filter):
 		if m.focus == focussedList {
+			m.snapshotFollow()
 			m.keys.listSelect.SetEnabled(false)
+			if m.lfilter.metric != nil {
+				m.lfilter.metric = nil
+				m.list.ResetFilter()
+			}
 		}
 
 	case key.Matches(msg, lkm.ClearFilter, lkm.CancelWhileFiltering, lkm.AcceptWhileFiltering):
 		if m.focus == focussedList {
 			m.keys.listSelect.SetEnabled(true)
 		}
+		if key.Matches(msg, lkm.ClearFilter) {
+			m.lfilter.metric = nil
+			m.list.ResetFilter()
+			cmd = m.restoreFollow()
+			cmds = append(cmds, cmd)
+		}
+
+	case key.Matches(msg, m.keys.metricFilter):
+		m.prompting = true
+		m.searching = false
+		m.promptErr = ""
+		m.prompt.Prompt = "metric filter> "
+		m.prompt.Placeholder = "e.g. lev>50,lines>=10"
+		m.prompt.Reset()
+		cmd = m.prompt.Focus()
+		cmds = append(cmds, cmd)
+
+	case key.Matches(msg, m.keys.significant):
+		if m.significantOnly() {
+			m.lfilter.metric = nil
+			m.list.ResetFilter()
+			cmds = append(cmds, m.restoreFollow(), m.switchContent(), m.toast("Showing every change"))
+			break
+		}
+		cmd, err := m.applyMetricFilter(m.significantExpr())
+		if err != nil {
+			printErrf("Unexpected metric filter error: %v", err)
+			return tea.Quit
+		}
+		cmds = append(cmds, cmd, m.toast("Showing significant changes only"))
+
+	case key.Matches(msg, m.keys.search):
+		m.prompting = true
+		m.searching = true
+		m.promptErr = ""
+		m.prompt.Prompt = "search> "
+		m.prompt.Placeholder = "empty to clear"
+		m.prompt.SetValue(m.query)
+		cmd = m.prompt.Focus()
+		cmds = append(cmds, cmd)
 
 	case key.Matches(msg, m.keys.switchContentUp):
 		m.follow = false
@@ -492,47 +1298,228 @@ func (m *model) handleKey(msg tea.KeyMsg) tea.Cmd {
 		cmds = append(cmds, cmd)
 
 	case key.Matches(msg, m.keys.diffMode):
-		m.lineDiff = !m.lineDiff
+		m.diff = (m.diff + 1) % numDiffModes
+		cmd = m.switchDiffContent()
+		cmds = append(cmds, cmd)
+
+	case key.Matches(msg, m.keys.switchStream):
+		m.stream = (m.stream + 1) % numStreams
 		cmd = m.switchDiffContent()
 		cmds = append(cmds, cmd)
 
+	case key.Matches(msg, m.keys.toggleRaw):
+		m.raw = !m.raw
+		cmd = m.switchDiffContent()
+		cmds = append(cmds, cmd)
+		if m.raw {
+			cmds = append(cmds, m.toast("Showing the output before filtering"))
+		} else {
+			cmds = append(cmds, m.toast("Showing the filtered output"))
+		}
+
+	case key.Matches(msg, m.keys.unchanged):
+		m.unchg = (m.unchg + 1) % numUnchangedModes
+		// Line diffs are rendered again in the new mode when shown
+		for _, h := range m.hist {
+			h.diffL = [numStreams]*renderedDiff{}
+		}
+		cmd = m.switchDiffContent()
+		cmds = append(cmds, cmd)
+		cmds = append(cmds, m.toast("Unchanged lines: "+m.unchg.String()))
+
 	case key.Matches(msg, m.keys.toggleFollow):
 		m.follow = !m.follow
+		// An explicit choice, which clearing the filter must not undo
+		m.fltSnap = nil
 		if m.follow {
-			m.list.ResetFilter()
+			if m.fltFollw == followPause {
+				m.lfilter.metric = nil
+				m.list.ResetFilter()
+			}
 			i := m.list.Index()
 			m.list.ResetSelected()
 			if m.focus == focussedPager && i != 0 {
 				cmd = m.switchContent()
 				cmds = append(cmds, cmd)
 			}
+			cmds = append(cmds, m.toast("Following the newest output"))
+		} else {
+			cmds = append(cmds, m.toast("Stopped following"))
+		}
+
+	case key.Matches(msg, m.keys.goLive):
+		m.follow = true
+		m.fltSnap = nil
+		m.lfilter.metric = nil
+		m.list.ResetFilter()
+		m.list.ResetSelected()
+		m.focus = focussedPager
+		m.setFocusHelp(switchFocusDescPager)
+		m.keys.listSelect.SetEnabled(false)
+		// Diffs are with the previous entry again, those are the ones cached
+		rediff := m.anchor != anchorPrev
+		m.baseID = nil
+		m.anchor = anchorPrev
+		if len(m.list.Items()) > 0 {
+			cmd = m.doSwitchContent(rediff)
+			cmds = append(cmds, cmd)
+		}
+		cmds = append(cmds, m.toast("Back to live"))
+
+	case key.Matches(msg, m.keys.stepReplay):
+		cmd = m.runNow()
+		cmds = append(cmds, cmd)
+
+	case key.Matches(msg, m.keys.toggleDeltas):
+		m.iview.deltas = !m.iview.deltas
+		if m.iview.deltas {
+			cmds = append(cmds, m.toast("Showing size deltas"))
+		} else {
+			cmds = append(cmds, m.toast("Showing sizes"))
+		}
+
+	case key.Matches(msg, m.keys.diffAnchor):
+		m.anchor = (m.anchor + 1) % numAnchors
+		if m.anchor == anchorBase && m.baseID == nil {
+			// Nothing to compare with until an entry is pinned
+			m.anchor++
+		}
+		cmd = m.switchDiffContent()
+		cmds = append(cmds, cmd)
+		cmds = append(cmds, m.toast("Comparing with the "+m.anchor.describe()))
+
+	case key.Matches(msg, m.keys.pinBase):
+		switch {
+		case m.seleID == nil:
+		case m.baseID != nil && *m.baseID == *m.seleID:
+			m.baseID = nil
+			if m.anchor == anchorBase {
+				m.anchor = anchorPrev
+			}
+			cmds = append(cmds, m.switchDiffContent(), m.toast("Unpinned the baseline"))
+		default:
+			id := *m.seleID
+			m.baseID = &id
+			m.anchor = anchorBase
+			cmds = append(cmds, m.switchDiffContent(), m.toast("Pinned as baseline"))
+		}
+
+	case key.Matches(msg, m.keys.nextChange):
+		m.jumpToChange(true)
+
+	case key.Matches(msg, m.keys.prevChange):
+		m.jumpToChange(false)
+
+	case key.Matches(msg, m.keys.toggleErrExit):
+		m.runner.errExit = !m.runner.errExit
+		if m.runner.errExit {
+			cmds = append(cmds, m.toast("Exiting when the command fails"))
+		} else {
+			cmds = append(cmds, m.toast("No longer exiting when the command fails"))
+		}
+
+	case key.Matches(msg, m.keys.toggleChgExit):
+		m.runner.chgExit = !m.runner.chgExit
+		if m.runner.chgExit {
+			cmds = append(cmds, m.toast("Exiting when the output changes"))
+		} else {
+			cmds = append(cmds, m.toast("No longer exiting when the output changes"))
 		}
 
 	case key.Matches(msg, m.keys.togglePause):
+		m.blurred = false
 		m.paused = !m.paused
 		cmd = m.timer.Toggle()
 		cmds = append(cmds, cmd)
 		slog.Debug("Timer toggle", "t", m.timer.Timeout, "paused", m.paused)
+		if m.paused {
+			cmds = append(cmds, m.toast("Paused"))
+		} else {
+			cmds = append(cmds, m.toast("Resumed"))
+		}
 
-	case key.Matches(msg, lkm.ClearFilter):
-		if m.focus == focussedPager {
-			m.list.ResetFilter()
+	case key.Matches(msg, m.keys.openPager):
+		if m.seleID != nil {
+			cmd = m.openPager(m.hist[*m.seleID].text(m.stream))
+			cmds = append(cmds, cmd)
+		}
+
+	case key.Matches(msg, m.keys.resetTimer):
+		cmds = append(cmds, m.resetTimer())
+
+	case key.Matches(msg, m.keys.copyPatch):
+		cmds = append(cmds, m.copyPatch())
+
+	case key.Matches(msg, m.keys.openEditor):
+		if m.seleID != nil {
+			cmd = m.openEditor(m.hist[*m.seleID].text(m.stream))
+			cmds = append(cmds, cmd)
 		}
 
 	case key.Matches(msg, lkm.ShowFullHelp, lkm.CloseFullHelp):
-		m.help.ShowAll = !m.help.ShowAll
+		m.keysHelp = true
 
 	case key.Matches(msg, lkm.Quit, lkm.ForceQuit):
-		cmd = tea.Quit
-		cmds = append(cmds, cmd)
+		if m.confirm && !key.Matches(msg, lkm.ForceQuit) {
+			m.quitting = true
+		} else {
+			cmd = tea.Quit
+			cmds = append(cmds, cmd)
+		}
 
 	}
 
 	return tea.Batch(cmds...)
 }
 
+func (m *model) handlePrompt(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.prompting = false
+		m.prompt.Blur()
+		return nil
+	case tea.KeyEnter:
+		if m.searching {
+			m.prompting = false
+			m.prompt.Blur()
+			m.search(m.prompt.Value())
+			if len(m.query) == 0 {
+				return m.toast("Search cleared")
+			}
+			return m.toast(fmt.Sprintf("%d matches for %q", m.matches, m.query))
+		}
+		cmd, err := m.applyMetricFilter(m.prompt.Value())
+		if err != nil {
+			m.promptErr = err.Error()
+			return nil
+		}
+		m.prompting = false
+		m.prompt.Blur()
+		return tea.Batch(cmd, m.toast("Filtered by "+m.prompt.Value()))
+	default:
+		var cmd tea.Cmd
+		m.prompt, cmd = m.prompt.Update(msg)
+		return cmd
+	}
+}
+
+// handleQuitConfirm quits on the quit key or "y", and goes back on esc or "n".
+func (m *model) handleQuitConfirm(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, m.list.KeyMap.Quit, m.list.KeyMap.ForceQuit) || msg.String() == "y":
+		return tea.Quit
+	case msg.Type == tea.KeyEsc || msg.String() == "n":
+		m.quitting = false
+	}
+	return nil
+}
+
 func (m *model) handleCmdCycle(msg cmdMsg) (tea.Cmd, bool) {
 	slog.Debug("Command completed")
+	m.busy = false
+	m.failed = msg.err != nil
+	m.runs++
+	m.pid = msg.out.pid
 
 	var (
 		cmd  tea.Cmd
@@ -540,152 +1527,638 @@ func (m *model) handleCmdCycle(msg cmdMsg) (tea.Cmd, bool) {
 	)
 
 	now := time.Now()
-	msgS := string(msg.out)
-	isDifferent := false
+	if !msg.out.at.IsZero() {
+		now = msg.out.at
+	}
+	if m.replay != nil {
+		m.replay.next++
+	}
+	raw, text := m.runner.normalize(msg.out)
+	msgS, errS, bothS := text[streamOut], text[streamErr], text[streamBoth]
+
+	if m.accum {
+		m.appendRun(now, exitCode(msg.err), text)
+	}
 
-	if m.prevT == nil {
-		isDifferent = true
-		m.seleT = &now
-		m.pager.SetContent(msgS)
-	} else if m.hist[*m.prevT].plain != msgS {
-		isDifferent = true
+	first := m.prevID == nil
+	var prev *[numStreams]string
+	if !first {
+		h := m.hist[*m.prevID]
+		prev = &[numStreams]string{streamOut: h.plain, streamErr: h.stderr, streamBoth: h.combined}
+	}
+	isDifferent := m.runner.differs(prev, text)
+	if m.runner.debounce > 0 && !first {
+		isDifferent = m.runner.settled(isDifferent, text, now)
 	}
+	// Failures get their own entry even if the output did not change, and
+	// so does the recovery from them
+	failChanged := !first && (msg.err != nil) != (m.hist[*m.prevID].err != "")
 
-	if isDifferent {
-		m.hist[now] = newHistoryEntry(msgS, m.prevT)
-		m.prevT = &now
-		cmd = m.list.InsertItem(0, newListItem(now, len(msgS), strings.Count(msgS, "\n")))
+	if isDifferent || failChanged {
+		if isDifferent && m.prevID != nil {
+			m.changes++
+		}
+		m.lastID++
+		id := m.lastID
+		item := newListItem(id, now, len(msgS), strings.Count(msgS, "\n"), msg.out.usage, m.iview)
+		item.exit = exitCode(msg.err)
+		item.dur = msg.out.dur
+		if m.prevID != nil {
+			prevS := m.hist[*m.prevID].plain
+			dChars, dLines := item.nChars-len(prevS), item.nLines-strings.Count(prevS, "\n")
+			item.dChars, item.dLines = &dChars, &dLines
+		}
+		if g := m.flapsTo(msgS); g != nil {
+			m.flaps++
+			item.flapTo = g.id
+		}
+		m.hist[id] = newHistoryEntry(now, msgS, errS, bothS, m.prevID)
+		if msg.err != nil {
+			m.hist[id].err = msg.err.Error()
+		}
+		if m.runner.pipeline.active() {
+			m.hist[id].raw = &raw
+		}
+		if m.prevID == nil {
+			m.seleID = &id
+			m.setPagerContent(highlightText(m.hist[id].text(m.stream)))
+		}
+		m.prevID = &id
+		cmd = m.list.InsertItem(0, item)
 		cmds = append(cmds, cmd)
-		if m.follow {
+		if m.lfilter.metric != nil {
+			cmd = m.computeMetrics()
+			cmds = append(cmds, cmd)
+		}
+		// While filtering, the visible items are stale until the filter
+		// results arrive, and the selection is fixed then, see followFiltered
+		if m.follow && !m.filtering() {
 			cmd = m.switchContent()
 			cmds = append(cmds, cmd)
-		} else {
+		} else if !m.filtering() {
 			m.list.CursorDown()
-		}
-	}
-
-	if msg.err != nil {
-		var ee *exec.ExitError
-		if errors.As(msg.err, &ee) {
-			if !ee.Success() && m.errExit {
-				printErr(errTxtExit)
-				return tea.Quit, true
+			if m.anchor == anchorNewest {
+				// The selection stays, but what it is compared with changed
+				cmd = m.switchDiffContent()
+				cmds = append(cmds, cmd)
 			}
-		} else {
-			printErrf("Failed to run command: %v", msg.err)
-			return tea.Quit, true
 		}
+		if m.flash && m.hist[id].prevID != nil {
+			cmd = m.notify()
+			cmds = append(cmds, cmd)
+		}
+	}
+	// Small changes leave the output as recorded, which is what is counted,
+	// while those waiting to settle are not counted until they are recorded
+	if m.runner.pending == nil {
+		m.countOutput(m.hist[*m.prevID].plain, *m.prevID)
+	}
+	prevS := ""
+	if id := m.hist[*m.prevID].prevID; id != nil {
+		prevS = m.hist[*id].plain
+	}
+	publishOutput(msgS, prevS)
+	rm := runMetrics{
+		runs:     m.runs,
+		changes:  m.changes,
+		exitCode: exitCode(msg.err),
+		outBytes: len(msgS),
+		duration: msg.out.dur,
+	}
+	publishMetrics(rm)
+	if m.accum && m.follow {
+		m.showAccumulated()
 	}
 
-	if m.chgExit && m.prevT != nil && isDifferent {
-		printErr(errTxtChg)
-		return tea.Quit, true
+	if ee := m.runner.checkExit(msg.err, isDifferent && !first); ee != nil {
+		ee.print()
+		m.rc = ee.code
+		return tea.Sequence(writeMetrics(rm), tea.Quit), true
 	}
+	cmds = append(cmds, writeMetrics(rm))
 
-	if !m.paused {
-		m.timer = timer.New(m.interval)
+	m.wait = m.runner.next(jitterInterval(m.interval, m.jitter))
+	if m.replay != nil {
+		m.wait = m.replay.wait()
+	}
+	m.elapsed = 0
+	if !m.paused && (m.replay == nil || !m.replay.done()) {
+		m.timer = newTimer(m.wait)
 		cmds = append(cmds, m.timer.Init())
 	}
 
 	return tea.Batch(cmds...), false
 }
 
-func (m *model) switchContent() tea.Cmd {
-	return m.doSwitchContent(false)
+// newTimer returns a timer for d, ticking at most every second and evenly
+// enough to time out right after d.
+func newTimer(d time.Duration) timer.Model {
+	d = max(d, time.Millisecond)
+	ticks := (d + time.Second - 1) / time.Second
+	return timer.NewWithInterval(d, d/ticks)
 }
 
-func (m *model) switchDiffContent() tea.Cmd {
-	return m.doSwitchContent(true)
+// resetTimer restarts the countdown to the next run from the whole interval.
+// A paused loop stays paused, with the countdown starting over once resumed.
+func (m *model) resetTimer() tea.Cmd {
+	// The timer is replaced anyway once the run is done, and replays keep
+	// their own timing
+	if m.busy || m.replay != nil {
+		return nil
+	}
+	m.wait = m.runner.next(jitterInterval(m.interval, m.jitter))
+	m.elapsed = 0
+	m.timer = newTimer(m.wait)
+	if m.paused {
+		// A new timer runs, stop it right away for resuming to start it
+		m.timer, _ = m.timer.Update(m.timer.Stop()())
+		return m.toast("Countdown reset")
+	}
+	return tea.Batch(m.timer.Init(), m.toast("Countdown restarted"))
 }
 
-func (m *model) doSwitchContent(changedDiffMode bool) tea.Cmd {
+// jitterInterval randomizes d by up to pct percent in either direction.
+func jitterInterval(d time.Duration, pct float64) time.Duration {
+	if pct <= 0 {
+		return d
+	}
+	f := 1 + pct/100*(2*rand.Float64()-1) //nolint:gosec // Not used for security
+	return time.Duration(float64(d) * f)
+}
+
+const flashDuration = 200 * time.Millisecond
+
+// How long feedback on an action is shown
+const toastDuration = 2 * time.Second
+
+// notify flashes for a change, unless one was notified less than the
+// throttle ago: those are held back, and notified at once when it is over.
+func (m *model) notify() tea.Cmd {
+	if m.throttle <= 0 {
+		return m.startFlash()
+	}
+	if since := time.Since(m.notified); since < m.throttle {
+		m.held++
+		if m.held > 1 {
+			return nil
+		}
+		return tea.Tick(m.throttle-since, func(time.Time) tea.Msg {
+			return throttleEndMsg{}
+		})
+	}
+	m.notified = time.Now()
+	return m.startFlash()
+}
+
+// toast shows s in place of the help for a while, as feedback on an action.
+func (m *model) toast(s string) tea.Cmd {
+	m.toastMsg = s
+	m.toastID++
+	id := m.toastID
+	return tea.Tick(toastDuration, func(time.Time) tea.Msg {
+		return toastEndMsg{id}
+	})
+}
+
+func (m *model) startFlash() tea.Cmd {
+	m.flashing = true
+	m.flashID++
+	id := m.flashID
+	return tea.Tick(flashDuration, func(time.Time) tea.Msg {
+		return flashEndMsg{id}
+	})
+}
+
+// snapshotFollow remembers whether to follow once the list is no longer
+// filtered, unless it already is.
+func (m *model) snapshotFollow() {
+	if m.fltSnap == nil {
+		follow := m.follow
+		m.fltSnap = &follow
+	}
+}
+
+// restoreFollow follows again after the filter of the list was cleared, if it
+// did before the list was filtered.
+func (m *model) restoreFollow() tea.Cmd {
+	if m.fltSnap == nil {
+		return nil
+	}
+	follow := *m.fltSnap
+	m.fltSnap = nil
+	if !follow {
+		return nil
+	}
+	m.follow = true
+	m.list.ResetSelected()
+	if m.focus == focussedPager {
+		return m.switchContent()
+	}
+	return nil
+}
+
+// followFiltered updates the selection once the filtered history list changed:
+// following tracks the newest matching entry, unless told to pause while the
+// list is filtered. Otherwise the selected entry stays selected.
+func (m *model) followFiltered() tea.Cmd {
+	visible := m.list.VisibleItems()
+	if !m.filtering() || len(visible) == 0 {
+		return nil
+	}
+	if m.follow && m.fltFollw == followTrack {
+		m.list.Select(0)
+		if m.focus == focussedPager {
+			return m.switchContent()
+		}
+		return nil
+	}
+	if m.seleID != nil {
+		i := slices.IndexFunc(visible, func(item list.Item) bool {
+			li, ok := item.(listItem)
+			return ok && li.id == *m.seleID
+		})
+		if i >= 0 {
+			m.list.Select(i)
+		}
+	}
+	return nil
+}
+
+// filtering tells whether the history list is filtered, or being filtered.
+func (m *model) filtering() bool {
+	return m.list.FilterState() != list.Unfiltered
+}
+
+// setItem replaces the item of the same entry as li. The list indexes the
+// selection by the visible items, which differ from its items while filtered.
+func (m *model) setItem(li listItem) tea.Cmd {
+	i := slices.IndexFunc(m.list.Items(), func(item list.Item) bool {
+		other, ok := item.(listItem)
+		return ok && other.id == li.id
+	})
+	if i < 0 {
+		return nil
+	}
+	return m.list.SetItem(i, li)
+}
+
+// capturesKeys tells whether keys go to an input, e.g. a filter, instead of
+// being bindings.
+func (m model) capturesKeys() bool {
+	return m.prompting || m.quitting || m.keysHelp || m.list.SettingFilter() || m.groups.SettingFilter()
+}
+
+// setFocusHelp describes what the focus switch key switches to.
+func (m *model) setFocusHelp(desc string) {
+	m.keys.switchFocus.SetHelp(m.keys.switchFocus.Keys()[0], desc)
+}
+
+func (m *model) switchContent() tea.Cmd {
+	return m.doSwitchContent(false)
+}
+
+func (m *model) switchDiffContent() tea.Cmd {
+	return m.doSwitchContent(true)
+}
+
+func (m *model) doSwitchContent(changedDiffMode bool) tea.Cmd {
 	si := m.list.SelectedItem()
+	if si == nil {
+		// Nothing to show, e.g. before the first output or when the filter
+		// hides every entry
+		slog.Debug("Clearing content, no entry selected")
+		m.content, m.matches = "", 0
+		m.hunks = nil
+		m.pager.SetContent("")
+		m.seleID = nil
+		return nil
+	}
 	sli, ok := si.(listItem)
 	if !ok {
 		printErrf("Unexpected list item type: %v", si)
 		return tea.Quit
 	}
-	if !changedDiffMode && m.seleT != nil && sli.t.Equal(*m.seleT) {
+	if !changedDiffMode && m.seleID != nil && sli.id == *m.seleID {
 		return nil
 	}
 	var (
 		content *string
+		hunks   []int
 		cmd     tea.Cmd
 	)
-	seleHist := m.hist[sli.t]
-	if seleHist.prevT == nil {
-		slog.Debug("Switching content to oldest entry")
-		content = &seleHist.plain
+	seleHist := m.hist[sli.id]
+	if m.sticky {
+		if changedDiffMode || seleHist.diff == nil {
+			diff := m.diff
+			seleHist.diff = &diff
+		} else {
+			m.diff = *seleHist.diff
+		}
+	}
+	seleText := seleHist.text(m.stream)
+	fromID, toID := m.diffIDs(sli.id)
+	if m.raw {
+		slog.Debug("Switching content to raw output")
+		seleText = highlightText(seleHist.rawText(m.stream))
+		content = &seleText
+	} else if fromID == nil {
+		slog.Debug("Switching content to entry without a diff")
+		seleText = highlightText(seleText)
+		content = &seleText
+	} else if m.diff == diffOff {
+		slog.Debug("Switching content to plain output")
+		seleText = highlightText(seleText)
+		content = &seleText
+	} else if m.diff == diffStacked {
+		slog.Debug("Switching content to stacked outputs")
+		from, to := m.hist[*fromID], m.hist[*toID]
+		stacked := stackOutputs(from.t, highlightText(from.text(m.stream)), to.t, highlightText(to.text(m.stream)))
+		content = &stacked
 	} else {
-		slog.Debug("Switching content to diff", "lineDiff", m.lineDiff)
-		prevHist := m.hist[*seleHist.prevT]
-		if m.lineDiff {
-			if seleHist.diffL == nil {
+		slog.Debug("Switching content to diff", "diff", m.diff, "stream", m.stream)
+		fromText, toText := m.hist[*fromID].text(m.stream), m.hist[*toID].text(m.stream)
+		var rendered *renderedDiff
+		if m.anchor != anchorPrev {
+			// Not cached, as what entries are compared with changes
+			var r renderedDiff
+			if m.diff == diffLine {
+				r = renderLineDiff(m.lineDiffs(fromText, toText), m.unchg)
+			} else {
+				diffs := m.charDiffs(fromText, toText)
+				r = renderedDiff{text: renderCharDiff(diffs, m.markers), hunks: diffHunks(diffs)}
+			}
+			rendered = &r
+		} else if m.diff == diffLine {
+			if seleHist.diffL[m.stream] == nil {
 				slog.Debug("Computing line diff")
-				ti1, ti2, linesIdx := m.dmp.DiffLinesToChars(prevHist.plain, seleHist.plain)
-				diffChars := m.dmp.DiffMain(ti1, ti2, true)
-				diffs := m.dmp.DiffCharsToLines(diffChars, linesIdx)
-				sli.update(m.dmp, diffs)
-				cmd = m.list.SetItem(m.list.Index(), sli)
-				diffsPretty := m.dmp.DiffPrettyText(diffs)
-				seleHist.diffL = &diffsPretty
+				diffs := m.lineDiffs(fromText, toText)
+				sli.update(m.dmp, diffs, m.cntLines)
+				cmd = m.setItem(sli)
+				rendered := renderLineDiff(diffs, m.unchg)
+				seleHist.diffL[m.stream] = &rendered
 			}
-			content = seleHist.diffL
+			rendered = seleHist.diffL[m.stream]
 		} else {
-			if seleHist.diffC == nil {
+			if seleHist.diffC[m.stream] == nil {
 				slog.Debug("Computing char diff")
-				diffs := m.dmp.DiffMain(prevHist.plain, seleHist.plain, true)
-				diffs = m.dmp.DiffCleanupSemanticLossless(diffs)
-				sli.update(m.dmp, diffs)
-				cmd = m.list.SetItem(m.list.Index(), sli)
-				diffsPretty := m.dmp.DiffPrettyText(diffs)
-				seleHist.diffC = &diffsPretty
+				diffs := m.charDiffs(fromText, toText)
+				sli.update(m.dmp, diffs, false)
+				cmd = m.setItem(sli)
+				seleHist.diffC[m.stream] = &renderedDiff{text: renderCharDiff(diffs, m.markers), hunks: diffHunks(diffs)}
 			}
-			content = seleHist.diffC
+			rendered = seleHist.diffC[m.stream]
 		}
+		content, hunks = &rendered.text, rendered.hunks
 	}
 	slog.Debug("Setting content")
-	m.pager.SetContent(*content)
-	m.seleT = &sli.t
+	m.setPagerContent(*content)
+	m.seleID = &sli.id
+	m.hunks = hunks
+	if m.accum && m.follow {
+		m.showAccumulated()
+		m.hunks = nil
+	} else if len(hunks) > 0 {
+		m.pager.SetYOffset(max(hunks[0]-hunkContext, 0))
+	}
 	return cmd
 }
 
+// diffIDs tells which entries to compare to display the entry id, from the
+// older to the newer, according to the anchor. Without an entry to compare
+// with, from is nil.
+func (m model) diffIDs(id entryID) (from, to *entryID) {
+	switch m.anchor {
+	case anchorFirst:
+		// Entries are numbered from 1
+		first := entryID(1)
+		from, to = &first, &id
+	case anchorBase:
+		from, to = m.baseID, &id
+	case anchorNewest:
+		from, to = &id, m.prevID
+	default:
+		return m.hist[id].prevID, &id
+	}
+	if from != nil && *from == *to {
+		from = nil
+	}
+	return from, to
+}
+
+// stackOutputs renders the previous output above the current one, each under
+// a divider with its time.
+func stackOutputs(prevT time.Time, prev string, t time.Time, cur string) string {
+	divider := func(label string, t time.Time) string {
+		return pagerSepStyle.Render("── "+label+" "+t.Format(time.TimeOnly)+" ──") + "\n"
+	}
+	if len(prev) > 0 && !strings.HasSuffix(prev, "\n") {
+		prev += "\n"
+	}
+	return divider("previous", prevT) + prev + divider("current", t) + cur
+}
+
+// appendRun adds the output of the run at t, which exited with rc, to the
+// accumulated output.
+func (m *model) appendRun(t time.Time, rc int, out [numStreams]string) {
+	sep := runSeparator(m.sepFmt, t, rc, m.runs) + "\n"
+	for s, text := range out {
+		if len(text) > 0 && !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		m.acc[s] += sep + text
+	}
+}
+
+// showAccumulated displays the accumulated output. View keeps it scrolled to
+// its end while following.
+func (m *model) showAccumulated() {
+	m.setPagerContent(highlightText(strings.TrimSuffix(m.acc[m.stream], "\n")))
+}
+
+// openPager suspends the TUI and pipes txt to the configured pager command.
+func (m model) openPager(txt string) tea.Cmd {
+	args := strings.Fields(m.pagerCmd)
+	c := exec.Command(args[0], args[1:]...) //nolint: gosec
+	c.Stdin = strings.NewReader(txt)
+	return tea.ExecProcess(c, func(err error) tea.Msg {
+		return execDoneMsg{err}
+	})
+}
+
+// openEditor suspends the TUI and opens txt in $EDITOR (or the pager) through
+// a temporary file, which is removed once the editor exits.
+func (m model) openEditor(txt string) tea.Cmd {
+	editor := os.Getenv("EDITOR")
+	if len(strings.TrimSpace(editor)) == 0 {
+		editor = m.pagerCmd
+	}
+	f, err := os.CreateTemp("", "a555watch-*.txt")
+	if err != nil {
+		return func() tea.Msg { return execDoneMsg{err} }
+	}
+	_, err = f.WriteString(txt)
+	if err = errors.Join(err, f.Close()); err != nil {
+		return func() tea.Msg { return execDoneMsg{errors.Join(err, os.Remove(f.Name()))} }
+	}
+	args := append(strings.Fields(editor), f.Name())
+	c := exec.Command(args[0], args[1:]...) //nolint: gosec
+	return tea.ExecProcess(c, func(err error) tea.Msg {
+		return execDoneMsg{errors.Join(err, os.Remove(f.Name()))}
+	})
+}
+
+// staleness tells why the displayed output may not be the live one, if it
+// may not: the loop is paused, an older entry is selected or the latest run
+// failed.
+func (m model) staleness() string {
+	switch {
+	case m.paused:
+		return "paused"
+	case m.seleID != nil && m.prevID != nil && *m.seleID != *m.prevID:
+		return "old"
+	case m.failed:
+		return "failed"
+	default:
+		return ""
+	}
+}
+
+// faint dims s, including the parts styled on their own which reset the
+// style when they end.
+func faint(s string) string {
+	if lipgloss.ColorProfile() == termenv.Ascii {
+		return s
+	}
+	return "\x1b[2m" + strings.ReplaceAll(s, "\x1b[0m", "\x1b[0;2m") + "\x1b[0m"
+}
+
+func (m *model) setPagerContent(s string) {
+	if len(s) == 0 {
+		s = pagerEmptyStyle.Render("(empty output)")
+	}
+	m.content = s
+	s, m.matches = highlightMatches(s, m.query)
+	m.pager.SetContent(s)
+}
+
+// Width of the progress bar towards the next run
+const progressWidth = 20
+
 func (m model) headerView() string {
-	left := fmt.Sprintf("Every %s: %s", m.interval, strings.Join(m.cmd, " "))
-	time := fmt.Sprintf("Next in %s", m.timer.View())
+	if len(m.hdrFmt) > 0 {
+		sty := lipgloss.NewStyle().Width(m.width - 2)
+		return m.headerStyle().Render(sty.Render(m.renderHeaderFormat()))
+	}
+	left := fmt.Sprintf("Every %s", m.interval)
+	if m.showHost {
+		pid := "n/a"
+		if m.pid > 0 {
+			pid = fmt.Sprint(m.pid)
+		}
+		left += fmt.Sprintf(" on %s (pid %s)", m.host, pid)
+	}
+	left += ": " + strings.Join(m.cmd, " ")
 	sty := lipgloss.NewStyle().Width(m.width/2 - 1)
+	right := m.nextView()
+	if m.progress && !m.busy && progressWidth+1+lipgloss.Width(right) <= m.width/2-1 {
+		right = m.bar.ViewAs(m.elapsed) + " " + right
+	}
 	s := lipgloss.JoinHorizontal(lipgloss.Center,
 		sty.Align(lipgloss.Left).Render(left),
-		sty.Align(lipgloss.Right).Render(time))
-	return headerStyle.Render(s)
+		sty.Align(lipgloss.Right).Render(right))
+	return m.headerStyle().Render(s)
+}
+
+func (m model) headerStyle() lipgloss.Style {
+	if m.flashing {
+		return headerStyle.Background(m.flashClr).Foreground(colorDark)
+	}
+	return headerStyle
+}
+
+// nextView describes what the command loop is doing.
+func (m model) nextView() string {
+	switch {
+	case m.busy:
+		return "Running…"
+	case m.replay != nil && m.replay.done():
+		return "Replay finished"
+	case m.blurred:
+		return "Paused (unfocused)"
+	case m.paused:
+		return "Paused"
+	default:
+		return fmt.Sprintf("Next in %s", formatTimer(m.timer.Timeout, m.tmrFmt))
+	}
 }
 
 func (m model) pagerTitleView() string {
 	var s string
-	if m.seleT == nil {
-		s = "n/a"
-	} else {
-		s = m.seleT.String()
+	switch {
+	case m.seleID == nil && m.prevID == nil:
+		s = "waiting for first output…"
+	case m.seleID == nil:
+		s = "no entry selected"
+	default:
+		s = m.hist[*m.seleID].t.String()
+		if err := m.hist[*m.seleID].err; err != "" {
+			s += " " + listItemFailStyle.Render("✗ "+err)
+		}
+		text := m.hist[*m.seleID].text(m.stream)
+		summary := pagerStatsStyle.Render(fmt.Sprintf("%s %d lines", bytes2String(len(text)), strings.Count(text, "\n")))
+		if stats := m.diffStatsView(); stats != "" {
+			summary += " " + stats
+		}
+		if from, _ := m.diffIDs(*m.seleID); m.anchor != anchorPrev && from != nil && !m.raw && m.diff != diffOff {
+			summary += " " + pagerStatsStyle.Render("compared with the "+m.anchor.describe())
+		}
+		s += "\n" + summary
 	}
 	return pagerTitleStyle.Width(m.width).Render(s)
 }
 
+// diffStatsView summarizes the diff of the selected entry, if one is displayed.
+func (m model) diffStatsView() string {
+	// The stats are those of the diff with the previous entry
+	if m.raw || m.anchor != anchorPrev || (m.diff != diffLine && m.diff != diffChar) {
+		return ""
+	}
+	sli, ok := m.list.SelectedItem().(listItem)
+	if !ok || sli.id != *m.seleID || m.hist[sli.id].prevID == nil || sli.levDist == nil {
+		return ""
+	}
+	pct := 0
+	if sli.nChars > 0 {
+		pct = *sli.levDist * 100 / sli.nChars
+	}
+	return pagerStatsStyle.Render(fmt.Sprintf("+%d%s −%d%s ~%d%% lev=%d",
+		*sli.additions, sli.countUnit(), *sli.deletions, sli.countUnit(), pct, *sli.levDist))
+}
+
+// exitOnView tells which exit conditions are on, if any.
+func (m model) exitOnView() string {
+	var conds []string
+	if m.runner.errExit {
+		conds = append(conds, "error")
+	}
+	if m.runner.chgExit {
+		conds = append(conds, "change")
+	}
+	return strings.Join(conds, "+")
+}
+
 func (m model) statusView() string {
 	var (
-		diffMode string
 		nItems   int
 		filtered string
 	)
 
-	if m.lineDiff {
-		diffMode = "line"
-	} else {
-		diffMode = "char"
-	}
-
 	if m.list.IsFiltered() {
 		nItems = len(m.list.VisibleItems())
 		filtered = "(filtered)"
+		if m.lfilter.metric != nil {
+			filtered = "(" + m.lfilter.metric.expr + ")"
+		}
 	} else {
 		nItems = len(m.list.Items())
 	}
@@ -694,65 +2167,197 @@ func (m model) statusView() string {
 		return statusKeyStyle.Render(k) + kvSep + statusValStyle.Render(v)
 	}
 
-	out := renderKV("diff", diffMode) + statusSep
-	out += renderKV("follow", bool2String(m.follow)) + statusSep
-	out += renderKV("paused", bool2String(m.paused)) + statusSep
+	out := renderKV("diff", m.diff.String()) + statusSep
+	out += renderKV("stream", m.stream.String()) + statusSep
+	out += renderKV("anchor", m.anchor.String()) + statusSep
+	if m.significantOnly() {
+		out += renderKV("filter", "significant") + statusSep
+	}
+	if exitOn := m.exitOnView(); exitOn != "" {
+		out += renderKV("exit-on", exitOn) + statusSep
+	}
+	if m.raw {
+		out += renderKV("raw", bool2String(m.raw)) + statusSep
+	}
+	if m.unchg != unchangedShow && m.diff == diffLine {
+		out += renderKV("unchanged", m.unchg.String()) + statusSep
+	}
+	if m.follow && m.list.IsFiltered() && m.fltFollw == followPause {
+		out += renderKV("follow", "paused") + statusSep
+	} else {
+		out += renderKV("follow", bool2String(m.follow)) + statusSep
+	}
+	if m.held > 0 {
+		out += renderKV("held", fmt.Sprintf("%d changes", m.held)) + statusSep
+	}
+	if m.blurred {
+		out += renderKV("paused", "auto") + statusSep
+	} else {
+		out += renderKV("paused", bool2String(m.paused)) + statusSep
+	}
+	if stale := m.staleness(); stale != "" {
+		out += renderKV("stale", stale) + statusSep
+	}
+	if m.replay != nil && m.replay.skipped > 0 {
+		out += renderKV("replay", m.replay.tailView()) + statusSep
+	}
+	if m.logFail {
+		out += renderKV("logs", "dropped") + statusSep
+	}
+	if len(m.query) > 0 {
+		out += renderKV("search", fmt.Sprintf("%q %d matches", m.query, m.matches)) + statusSep
+	}
 	out += renderKV("alt", bool2String(m.alt)) + statusSep
-	out += renderKV("selected", fmt.Sprintf("%d/%d", m.list.Index()+1, nItems)+filtered)
+	out += renderKV("changes", fmt.Sprintf("%d/%d", m.changes, m.runs)) + statusSep
+	out += renderKV("flaps", fmt.Sprint(m.flaps)) + statusSep
+	out += renderKV("elapsed", duration2String(time.Since(m.start))) + statusSep
+	out += renderKV("selected", fmt.Sprintf("%d/%d", min(m.list.Index()+1, nItems), nItems)+filtered)
+	if m.seleID != nil {
+		seleHist := m.hist[*m.seleID]
+		seleText := seleHist.text(m.stream)
+		size := bytes2String(len(seleText))
+		if *m.seleID == *m.prevID && seleHist.prevID != nil {
+			delta := len(seleText) - len(m.hist[*seleHist.prevID].text(m.stream))
+			if delta >= 0 {
+				size += "(+" + bytes2String(delta) + ")"
+			} else {
+				size += "(-" + bytes2String(-delta) + ")"
+			}
+		}
+		out += statusSep + renderKV("size", size)
+	}
 
 	return statusBarStyle.Width(m.width).Render(out)
 }
 
 func (m model) helpListView() string {
 	lkm := m.list.KeyMap
-	if m.help.ShowAll {
-		return m.help.FullHelpView([][]key.Binding{
-			{lkm.CursorUp, lkm.CursorDown, lkm.PrevPage, lkm.NextPage, lkm.GoToStart, lkm.GoToEnd},
-			{
-				m.keys.switchFocus,
-				lkm.Filter, lkm.ClearFilter, lkm.AcceptWhileFiltering, lkm.CancelWhileFiltering,
-				lkm.CloseFullHelp, lkm.Quit,
-			},
-		})
-	}
 	return m.help.ShortHelpView([]key.Binding{
 		m.keys.switchFocus, lkm.ShowFullHelp, lkm.Quit,
 	})
 }
 
 func (m model) helpPagerView() string {
-	pkm := m.pager.KeyMap
-	if m.help.ShowAll {
-		return m.help.FullHelpView([][]key.Binding{
-			{pkm.Up, pkm.Down, pkm.PageUp, pkm.PageDown, pkm.HalfPageUp, pkm.HalfPageDown},
-			{
-				m.keys.switchContentUp, m.keys.switchContentDown,
-				m.keys.diffMode, m.keys.toggleFollow, m.keys.togglePause,
-				m.keys.toggleAltScreen,
-			},
-			{m.keys.switchFocus, m.list.KeyMap.ClearFilter, m.list.KeyMap.CloseFullHelp, m.list.KeyMap.Quit},
-		})
-	}
 	return m.help.ShortHelpView([]key.Binding{
 		m.keys.switchFocus, m.list.KeyMap.ShowFullHelp, m.list.KeyMap.Quit,
 	})
 }
 
+func (m model) helpGroupsView() string {
+	return m.help.ShortHelpView([]key.Binding{
+		m.keys.listSelect, m.keys.toggleGroups, m.list.KeyMap.Filter, m.list.KeyMap.Quit,
+	})
+}
+
 func (m model) helpView() string {
 	var view string
-	if m.focus == focussedList {
+	switch {
+	case m.prompting:
+		view = m.prompt.View()
+		if len(m.promptErr) > 0 {
+			view += " " + errStyle.UnsetPadding().Render(m.promptErr)
+		}
+	case m.quitting:
+		view = "Quit? " + helpKeyStyle.Render("y/q") + " " + helpDescStyle.Render("yes") +
+			m.help.ShortSeparator + helpKeyStyle.Render("n/esc") + " " + helpDescStyle.Render("no")
+	case len(m.toastMsg) > 0:
+		view = toastStyle.Render(m.toastMsg)
+	case m.focus == focussedList:
 		view = m.helpListView()
-	} else {
+	case m.focus == focussedGroups:
+		view = m.helpGroupsView()
+	default:
 		view = m.helpPagerView()
 	}
-	sty := lipgloss.NewStyle().Margin(1, 1, 0, 1)
-	if !m.help.ShowAll {
-		sty = sty.Width(m.width - 2).Align(lipgloss.Center)
+
+	return lipgloss.NewStyle().Margin(1, 1, 0, 1).Width(m.width - 2).Align(lipgloss.Center).Render(view)
+}
+
+// keysHelpView lists every key binding by category, whatever is focussed.
+func (m model) keysHelpView() string {
+	lkm, pkm := m.list.KeyMap, m.pager.KeyMap
+	categories := []struct {
+		title    string
+		bindings []key.Binding
+	}{
+		{"List", []key.Binding{
+			lkm.CursorUp, lkm.CursorDown, lkm.PrevPage, lkm.NextPage, lkm.GoToStart, lkm.GoToEnd,
+			lkm.Filter, lkm.ClearFilter, lkm.AcceptWhileFiltering, lkm.CancelWhileFiltering, m.keys.listSelect,
+			m.keys.toggleDeltas,
+		}},
+		{"Pager", []key.Binding{
+			pkm.Up, pkm.Down, pkm.PageUp, pkm.PageDown, pkm.HalfPageUp, pkm.HalfPageDown,
+			m.keys.nextChange, m.keys.prevChange,
+		}},
+		{"Output", []key.Binding{
+			m.keys.switchContentUp, m.keys.switchContentDown, m.keys.diffMode, m.keys.unchanged, m.keys.diffAnchor,
+			m.keys.pinBase, m.keys.switchStream,
+			m.keys.toggleRaw, m.keys.toggleGroups, m.keys.metricFilter, m.keys.significant, m.keys.search, m.keys.openPager,
+			m.keys.openEditor, m.keys.copyPatch,
+		}},
+		{"Watch", []key.Binding{
+			m.keys.toggleFollow, m.keys.togglePause, m.keys.resetTimer, m.keys.goLive, m.keys.stepReplay, m.keys.toggleAltScreen,
+			m.keys.toggleErrExit, m.keys.toggleChgExit,
+		}},
+		{"General", []key.Binding{m.keys.switchFocus, lkm.ShowFullHelp, lkm.Quit}},
+	}
+
+	blocks := make([]string, 0, len(categories))
+	for _, c := range categories {
+		keys := []string{keysHelpTitleStyle.Render(c.title)}
+		descs := []string{""}
+		for _, b := range c.bindings {
+			if b.Help().Key == "" {
+				continue
+			}
+			// Keys which would not respond right now are dimmed
+			keySty, descSty := helpKeyStyle, helpDescStyle
+			if !b.Enabled() {
+				keySty, descSty = keySty.Faint(true), descSty.Faint(true)
+			}
+			keys = append(keys, keySty.Render(b.Help().Key))
+			descs = append(descs, descSty.Render(b.Help().Desc))
+		}
+		blocks = append(blocks, lipgloss.JoinHorizontal(lipgloss.Top,
+			lipgloss.JoinVertical(lipgloss.Left, keys...), "  ", lipgloss.JoinVertical(lipgloss.Left, descs...)))
+	}
+	body := lipgloss.JoinHorizontal(lipgloss.Top, interleave(blocks, "    ")...)
+	if lipgloss.Width(body)+keysHelpStyle.GetHorizontalFrameSize() > m.width {
+		body = lipgloss.JoinVertical(lipgloss.Left, interleave(blocks, "")...)
+	}
+	footer := helpDescStyle.Render("press any key to close")
+	box := keysHelpStyle.Render(lipgloss.JoinVertical(lipgloss.Center, body, "", footer))
+	return lipgloss.Place(m.width, m.viewHeight(), lipgloss.Center, lipgloss.Center, box)
+}
+
+// interleave puts sep between the elements of ss.
+func interleave(ss []string, sep string) []string {
+	out := make([]string, 0, 2*len(ss))
+	for i, s := range ss {
+		if i > 0 {
+			out = append(out, sep)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// viewHeight is the height of the TUI. Outside of the alt screen, it only
+// takes a region of the terminal which it redraws in place, leaving the
+// scrollback above alone.
+func (m model) viewHeight() int {
+	if m.alt || m.inline <= 0 {
+		return m.height
 	}
-	return sty.Render(view)
+	return min(m.inline, m.height)
 }
 
 func (m model) View() string {
+	if m.keysHelp {
+		return m.keysHelpView()
+	}
+
+	height := m.viewHeight()
 	headerView := m.headerView()
 	headerHeight := lipgloss.Height(headerView)
 
@@ -767,86 +2372,336 @@ func (m model) View() string {
 
 	switch m.focus {
 	case focussedList:
-		m.list.SetSize(m.width, m.height-headerHeight-statusHeight-helpHeight)
+		m.list.SetSize(m.width, height-headerHeight-statusHeight-helpHeight)
 		views = append(views, m.list.View())
+	case focussedGroups:
+		m.groups.SetSize(m.width, height-headerHeight-statusHeight-helpHeight)
+		views = append(views, m.groups.View())
 	case focussedPager:
 		pagerTitleView := m.pagerTitleView()
 		pagerTitleHeight := lipgloss.Height(pagerTitleView)
 		m.pager.Width = m.width
-		m.pager.Height = m.height - pagerTitleHeight - headerHeight - statusHeight - helpHeight
-		views = append(views, pagerTitleView, m.pager.View())
+		if m.flashing {
+			m.pager.Style = m.pager.Style.BorderForeground(m.flashClr)
+		}
+		m.pager.Height = height - pagerTitleHeight - headerHeight - statusHeight - helpHeight
+		if m.pagerH > 0 {
+			m.pager.Height = min(m.pager.Height, m.pagerH)
+		}
+		if m.accum && m.follow {
+			m.pager.GotoBottom()
+		}
+		pagerView := m.pager.View()
+		if m.staleness() != "" {
+			pagerView = faint(pagerView)
+		}
+		views = append(views, pagerTitleView, pagerView)
 	}
 	views = append(views, statusView, helpView)
 	return lipgloss.JoinVertical(lipgloss.Top, views...)
 }
 
+// runNow runs the command without waiting for the timer, which is dropped.
+// The next timer starts once the command is done.
+func (m *model) runNow() tea.Cmd {
+	if m.busy || (m.replay != nil && m.replay.done()) {
+		return nil
+	}
+	m.timer = timer.New(m.interval)
+	m.busy = true
+	return m.runCmd
+}
+
 func (m model) runCmd() tea.Msg {
-	cmd := exec.Command(m.cmd[0], m.cmd[1:]...) //nolint: gosec
-	out, err := cmd.Output()
+	if m.replay != nil {
+		return m.replay.output()
+	}
+	out, err := m.runner.exec(m.pager.Width, m.pager.Height)
 	return cmdMsg{out, err}
 }
 
-func mainTea(cmd []string) {
-	m := newModel(cmd)
+type runOpts struct {
+	tty        bool
+	retries    int
+	retryDelay time.Duration
+}
+
+func newRunOpts() runOpts {
+	return runOpts{tty: *flagTTY, retries: *flagRetries, retryDelay: *flagRetryDly}
+}
+
+// exec runs the command, retrying it while it fails. Only the last result is returned.
+// Width and height size the pseudo-terminal, if one is used.
+func (o runOpts) exec(argv []string, width, height int) (cmdOutput, error) {
+	for attempt := 1; ; attempt++ {
+		var (
+			c     = exec.Command(argv[0], argv[1:]...) //nolint: gosec
+			out   cmdOutput
+			err   error
+			start = time.Now()
+		)
+		if o.tty {
+			out.stdout, err = runTTY(c, width, height)
+			out.combined = out.stdout
+		} else {
+			out, err = runCaptured(c)
+		}
+		if c.Process != nil {
+			out.pid = c.Process.Pid
+		}
+		out.usage = newResUsage(c.ProcessState)
+		out.dur = time.Since(start)
+		if err == nil || attempt > o.retries {
+			return out, err
+		}
+		slog.Info("Command failed, retrying", "attempt", attempt, "retries", o.retries, "err", err)
+		time.Sleep(o.retryDelay)
+	}
+}
+
+func mainTea(cmd []string, pipeline outputPipeline, rp *replay) {
+	m := newModel(cmd, pipeline)
+	if rp != nil {
+		m.replay = rp
+		m.keys.stepReplay.SetEnabled(true)
+	}
+	if len(*flagRecord) > 0 {
+		width, height, err := term.GetSize(os.Stdout.Fd())
+		if err != nil {
+			width, height = 80, 24
+		}
+		if m.rec, err = newRecorder(*flagRecord, width, height); err != nil {
+			printErrf("Cannot record: %v", err)
+			os.Exit(1)
+		}
+	}
 
+	tm, err := runProgram(m)
+	if m.rec != nil {
+		// Before exiting, which skips deferred calls
+		if err := m.rec.Close(); err != nil {
+			printErrf("Cannot record: %v", err)
+		}
+	}
+	fm, ok := tm.(model)
+	if ok {
+		// Also covers SIGINT and SIGTERM, which make the program quit
+		saveSession(fm.session)
+		fm.writeCSV()
+		fm.writeChart()
+	}
+	if errors.Is(err, tea.ErrInterrupted) {
+		// An interrupt is no failure, and the session is saved already
+		os.Exit(128 + int(syscall.SIGINT))
+	}
+	if err != nil {
+		printErrf("Oops! %v", err)
+		os.Exit(exitFailure)
+	}
+	if !ok {
+		return
+	}
+	if id := fm.prevID; id != nil {
+		if *flagOutWhich == outputSelected && fm.seleID != nil {
+			id = fm.seleID
+		}
+		if *flagOutFmt == formatDiffJSON {
+			writeOutputFile(fm.diffJSON(*id))
+		} else {
+			writeOutputFile(fm.hist[*id].text(fm.stream))
+		}
+	}
+	if fm.rc != 0 {
+		os.Exit(fm.rc)
+	}
+}
+
+// runProgram runs the TUI of m, accepting commands on the control socket if asked to.
+func runProgram(m tea.Model) (tea.Model, error) {
 	var opts []tea.ProgramOption
 	if !*flagNoAlt {
 		opts = append(opts, tea.WithAltScreen())
 	}
-	if _, err := tea.NewProgram(m, opts...).Run(); err != nil {
-		printErrf("Oops! %v", err)
-		os.Exit(1)
+	if *flagAutoStop {
+		opts = append(opts, tea.WithReportFocus())
 	}
+	p := tea.NewProgram(m, opts...)
+	if logW != nil {
+		logW.notifyProgram(p)
+	}
+	var ctl net.Listener
+	if len(*flagControl) > 0 {
+		var err error
+		if ctl, err = listenControl(*flagControl, p); err != nil {
+			printErrf("Cannot listen on control socket: %v", err)
+			os.Exit(1)
+		}
+	}
+	tm, err := p.Run()
+	if ctl != nil {
+		// Also removes the socket file
+		ctl.Close()
+	}
+	return tm, err
 }
 
-func mainClassic(cmd []string) {
-	var prevOut *string
+func mainClassic(cmd []string, pipeline outputPipeline) {
+	var (
+		prev    *[numStreams]string
+		runs    int
+		changes int
+		oldOut  string
+		run     = newRunner(cmd, pipeline)
+		sess    = session{Command: cmd, Entries: nil}
+		getSess = func() session { return sess }
+		sigs    = make(chan os.Signal, 1)
+		limit   <-chan time.Time
+		cls     = *flagClear
+	)
+	if len(cls) == 0 {
+		// Clearing makes no sense in a pipe or a file
+		cls = clearNone
+		if term.IsTerminal(os.Stdout.Fd()) {
+			cls = clearANSI
+		}
+	}
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	if *flagFor > 0 {
+		limit = time.After(*flagFor)
+	}
+	if logW != nil {
+		logW.notify(func(err error) { printErrf("Cannot write logs, dropping them: %v", err) })
+	}
 	for {
-		fmt.Println("\x1B[2J\x1B[1;1H")
+		if !*flagAppend {
+			switch cls {
+			case clearANSI:
+				fmt.Println("\x1B[2J\x1B[1;1H")
+			case clearFormFeed:
+				fmt.Print("\f")
+			}
+		}
 
-		c := exec.Command(cmd[0], cmd[1:]...) //nolint: gosec
-		out, err := c.Output()
-		outS := string(out)
+		width, height, _ := term.GetSize(os.Stdout.Fd())
+		out, err := run.exec(width, height)
+		_, text := run.normalize(out)
+		outS := text[streamOut]
+		if *flagAppend {
+			fmt.Println(runSeparator(*flagSepFmt, time.Now(), exitCode(err), runs+1))
+		}
 		fmt.Println(outS)
 
-		if err != nil && *flagErrExit {
-			if ee, ok := err.(*exec.ExitError); ok {
-				printErr(errTxtExit)
-				if len(ee.Stderr) > 0 {
-					printErrf("%s", ee.Stderr)
-				}
+		// Compared with the recorded output, small changes add up
+		isDifferent := run.differs(prev, text)
+		if run.debounce > 0 && prev != nil {
+			isDifferent = run.settled(isDifferent, text, time.Now())
+		}
+		if isDifferent {
+			sess.Entries = append(sess.Entries, sessionEntry{
+				Time:     time.Now(),
+				Stdout:   outS,
+				Stderr:   text[streamErr],
+				Combined: text[streamBoth],
+			})
+		}
+
+		if ee := run.checkExit(err, isDifferent && prev != nil); ee != nil {
+			ee.print()
+			if ee.reason == errTxtExit && len(out.stderr) > 0 {
+				printErrf("%s", out.stderr)
 			}
-			os.Exit(c.ProcessState.ExitCode())
+			writeOutputFile(outS)
+			saveSession(getSess)
+			os.Exit(ee.code)
 		}
 
-		if *flagChgExit && prevOut != nil && *prevOut != outS {
-			printErr(errTxtChg)
-			os.Exit(c.ProcessState.ExitCode())
+		runs++
+		if isDifferent && prev != nil {
+			changes++
+			oldOut = prev[streamOut]
+		}
+		publishOutput(outS, oldOut)
+		rm := runMetrics{
+			runs:     runs,
+			changes:  changes,
+			exitCode: exitCode(err),
+			outBytes: len(outS),
+			duration: out.dur,
 		}
+		publishMetrics(rm)
+		writeMetricsFile(*flagMetrics, rm)
 
-		prevOut = &outS
+		if isDifferent {
+			prev = &text
+		}
 
-		time.Sleep(*flagInterval)
+		select {
+		case <-time.After(run.next(jitterInterval(*flagInterval, *flagJitter))):
+		case <-limit:
+			writeOutputFile(outS)
+			saveSession(getSess)
+			os.Exit(0)
+		case sig := <-sigs:
+			saveSession(getSess)
+			code := exitFailure
+			if sig, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(sig)
+			}
+			os.Exit(code)
+		}
 	}
 }
 
-func usage() {
+// bannerView renders the banner, or the one in the file named by the
+// environment, within width.
+func bannerView(width int) string {
 	bannerStyle := lipgloss.NewStyle().
 		Border(lipgloss.NormalBorder(), true).
 		BorderForeground(colorViolet).
 		Foreground(colorPink).
 		Padding(3, 3, 1, 3).
 		Margin(1, 3, 0, 3)
+
+	text := banner
+	if path := os.Getenv(envBanner); len(path) > 0 {
+		// The help is still worth showing without the custom banner
+		if b, err := os.ReadFile(path); err == nil {
+			text = string(b)
+		}
+	}
+	view := bannerStyle.Render(text)
+	if lipgloss.Width(view) > width {
+		// Drop the frame, and the banner itself if it still does not fit
+		view = ""
+		if lipgloss.Width(text) <= width {
+			view = lipgloss.NewStyle().Foreground(colorPink).Render(text)
+		}
+	}
+	return view
+}
+
+// Width of the usage when not printed to a terminal
+const usageWidth = 80
+
+func usage() {
 	progStyle := lipgloss.NewStyle().Foreground(colorPurple).Bold(true)
 	commandStyle := lipgloss.NewStyle().Foreground(colorPink).Underline(true)
 	optsStyle := lipgloss.NewStyle().Foreground(colorDark)
-	usage := fmt.Sprintf("%s\n\n%s %s %s\n\n%s",
-		bannerStyle.Render(banner),
+
+	// Leave room for the margin of the whole usage
+	width := usageWidth - 2
+	if w, _, err := term.GetSize(os.Stdout.Fd()); err == nil && w > 0 {
+		width = w - 2
+	}
+	usage := fmt.Sprintf("%s %s %s\n\n%s",
 		progStyle.Render(os.Args[0]),
 		optsStyle.Render("[options]"),
 		commandStyle.Render("command"),
-		flag.CommandLine.FlagUsages(),
+		flag.CommandLine.FlagUsagesWrapped(width),
 	)
+	if !*flagNoBanner {
+		usage = bannerView(width) + "\n\n" + usage
+	}
 	fmt.Fprintf(os.Stdout, "%s\n", lipgloss.NewStyle().Margin(0, 1).Render(usage))
 }
 
@@ -861,23 +2716,262 @@ func main() {
 		os.Exit(0)
 	}
 
+	// The flag, even if false, takes precedence over the environment
+	if v, ok := os.LookupEnv(envAlt); ok && !flag.Lookup("no-alt").Changed {
+		alt, err := strconv.ParseBool(v)
+		if err != nil {
+			printErrf("Invalid %s %q (want true or false)", envAlt, v)
+			os.Exit(1)
+		}
+		*flagNoAlt = !alt
+	}
+
 	if *flagVersion {
 		fmt.Printf("%s version %s (%s) built at %s\n", os.Args[0], buildVersion, buildCommit, buildDate)
 		os.Exit(0)
 	}
 
+	if *flagClassic && *flagForceTUI {
+		printErr("Choose between --no-tui and --force-tui")
+		os.Exit(1)
+	}
+	// The TUI would only write escape sequences to a pipe or a file
+	if !*flagForceTUI && !term.IsTerminal(os.Stdout.Fd()) {
+		*flagClassic = true
+	}
+
 	cmd := flag.Args()
+	if len(*flagCmdFile) > 0 {
+		if len(cmd) > 0 {
+			printErr("Give either a command or a command file")
+			os.Exit(1)
+		}
+		f, err := os.Open(*flagCmdFile)
+		if err != nil {
+			printErrf("Cannot read command file: %v", err)
+			os.Exit(1)
+		}
+		f.Close()
+		// The shell reads the file on every run, so that it can be edited
+		cmd = []string{"sh", *flagCmdFile}
+	}
+	if len(*flagWaitFile) > 0 && len(*flagWaitPort) > 0 {
+		printErr("Choose between --until-file and --until-port")
+		os.Exit(1)
+	}
+	if len(*flagWaitPort) > 0 {
+		if _, _, err := net.SplitHostPort(*flagWaitPort); err != nil {
+			printErrf("Invalid port to wait for: %v", err)
+			os.Exit(1)
+		}
+	}
+	if p := newProbe(); p != nil {
+		if len(cmd) > 0 {
+			printErr("Give either a command or a condition to wait for")
+			os.Exit(1)
+		}
+		cmd = p.argv()
+	}
+	var rp *replay
+	if len(*flagReplay) > 0 {
+		if *flagClassic {
+			printErr("Replaying a session needs the TUI")
+			os.Exit(1)
+		}
+		if *flagSpeed <= 0 {
+			printErrf("Invalid replay speed %v (want a positive number)", *flagSpeed)
+			os.Exit(1)
+		}
+		if *flagRplTail < 0 {
+			printErrf("Invalid replay tail %d (want a positive number or 0)", *flagRplTail)
+			os.Exit(1)
+		}
+		var (
+			sess session
+			err  error
+		)
+		if rp, sess, err = loadReplay(*flagReplay, *flagSpeed, *flagRplTail); err != nil {
+			printErrf("Cannot load session: %v", err)
+			os.Exit(1)
+		}
+		cmd = sess.Command
+	}
 	if len(cmd) == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	var (
+		cmds   [][]string
+		layout string
+	)
+	if *flagSplit && *flagTabs {
+		printErr("Choose between --split and --tabs")
+		os.Exit(1)
+	}
+	if *flagSplit || *flagTabs {
+		cmds = splitCommands(cmd)
+		empty := slices.ContainsFunc(cmds, func(c []string) bool { return len(c) == 0 })
+		switch {
+		case *flagSplit && (len(cmds) != 2 || empty):
+			printErr("Splitting needs two commands separated by --")
+			os.Exit(1)
+		case *flagTabs && empty:
+			printErr("Tabs need commands separated by --")
+			os.Exit(1)
+		}
+		layout = layoutSplit
+		if *flagTabs {
+			layout = layoutTabs
+		}
+		if *flagClassic {
+			printErr("Watching several commands needs the TUI")
+			os.Exit(1)
+		}
+		for _, name := range singleCommandFlags {
+			if flag.Lookup(name).Changed {
+				printErrf("--%s needs a single command", name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Better to fail now than to show the failing runs in the TUI. Replays and
+	// conditions do not run anything.
+	if !*flagNoLookup && rp == nil && newProbe() == nil {
+		watched := cmds
+		if len(watched) == 0 {
+			watched = [][]string{cmd}
+		}
+		for _, c := range watched {
+			if _, err := exec.LookPath(c[0]); err != nil {
+				printErrf("Cannot run command: %v (use --no-lookup to skip this check)", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *flagSignif < 0 {
+		printErrf("Invalid significant change %d (want a positive number)", *flagSignif)
+		os.Exit(1)
+	}
+
+	if *flagMinChg < 0 {
+		printErrf("Invalid minimum change %d (want a positive number)", *flagMinChg)
+		os.Exit(1)
+	}
+
+	if *flagInline < 0 {
+		printErrf("Invalid inline height %d (want a positive number, or 0 for the whole terminal)", *flagInline)
+		os.Exit(1)
+	}
+
+	if *flagPagerH < 0 {
+		printErrf("Invalid pager height %d (want a positive number, or 0 for all the lines left)", *flagPagerH)
+		os.Exit(1)
+	}
+
+	if *flagParallel < 0 {
+		printErrf("Invalid concurrency %d (want a positive number, or 0 for no limit)", *flagParallel)
+		os.Exit(1)
+	}
+
+	if *flagJitter < 0 || *flagJitter >= 100 {
+		printErrf("Invalid jitter %v (want a percentage between 0 and 100)", *flagJitter)
+		os.Exit(1)
+	}
+
+	if err := validateClearMode(*flagClear); err != nil {
+		printErrf("%v", err)
+		os.Exit(1)
+	}
+
+	if err := validateBinaryMode(*flagBinary); err != nil {
+		printErrf("%v", err)
+		os.Exit(1)
+	}
+
+	if err := validateDiffCleanup(*flagCleanup); err != nil {
+		printErrf("%v", err)
+		os.Exit(1)
+	}
+
+	if *flagEditCost <= 0 {
+		printErrf("Invalid diff edit cost %d (want a positive number)", *flagEditCost)
+		os.Exit(1)
+	}
+
+	if *flagDiffTO < 0 {
+		printErrf("Invalid diff timeout %s (want a positive duration, or 0 for no timeout)", *flagDiffTO)
+		os.Exit(1)
+	}
+
+	var err error
+	if highlightRes, err = compileRegexps(*flagHilite); err != nil {
+		printErrf("Invalid highlight pattern: %v", err)
+		os.Exit(1)
+	}
+
+	for _, c := range []string{*flagFlashClr, *flagDiffAdd, *flagDiffDel} {
+		if err := validateColor(c); err != nil {
+			printErrf("%v", err)
+			os.Exit(1)
+		}
+	}
+	diffInsStyle = diffInsStyle.Foreground(lipgloss.Color(*flagDiffAdd))
+	diffDelStyle = diffDelStyle.Foreground(lipgloss.Color(*flagDiffDel))
+
+	switch *flagBg {
+	case bgAuto:
+		// Left to lipgloss, which asks the terminal
+	case bgDark, bgLight:
+		lipgloss.SetHasDarkBackground(*flagBg == bgDark)
+	default:
+		printErrf("Invalid background %q (want %s, %s or %s)", *flagBg, bgAuto, bgDark, bgLight)
+		os.Exit(1)
+	}
+
+	pipeline, err := newOutputPipeline()
+	if err != nil {
+		printErrf("%v", err)
+		os.Exit(1)
+	}
+
+	if err := validateFormat(*flagHdrFmt, headerPlaceholders); err != nil {
+		printErrf("Invalid header format, using the default: %v", err)
+		*flagHdrFmt = ""
+	}
+
+	if err := validateFormat(*flagSepFmt, separatorPlaceholders); err != nil {
+		printErrf("Invalid append separator, using the default: %v", err)
+		*flagSepFmt = flag.Lookup("append-separator").DefValue
+	}
+
+	if *flagFltFollw != followTrack && *flagFltFollw != followPause {
+		printErrf("Invalid follow mode %q (want %s or %s)", *flagFltFollw, followTrack, followPause)
+		os.Exit(1)
+	}
+
+	if err := validateTimerFormat(*flagTmrFmt); err != nil {
+		printErrf("%v", err)
+		os.Exit(1)
+	}
+
+	if err := validateListColumns(*flagListCols); err != nil {
+		printErrf("Invalid list columns: %v", err)
+		os.Exit(1)
+	}
+
+	if *flagOutWhich != outputNewest && *flagOutWhich != outputSelected {
+		printErrf("Invalid output to write %q (want %s or %s)", *flagOutWhich, outputNewest, outputSelected)
+		os.Exit(1)
+	}
+
 	var (
 		// Requesting a minimum log level that is greater than the maximum used (i.e. error).
 		// It should not try to actually print anything.
 		logF = os.Stderr
 		logL = LevelNoLogs
-		err  error
 	)
 
 	if len(*flagLog) > 0 {
@@ -887,6 +2981,7 @@ func main() {
 			os.Exit(1)
 		}
 		defer logF.Close()
+		logW = newLogWriter(logF)
 		if *flagDebug {
 			logL = slog.LevelDebug
 		} else {
@@ -895,21 +2990,144 @@ func main() {
 	}
 
 	slogOpts := slog.HandlerOptions{AddSource: true, Level: logL, ReplaceAttr: nil}
-	logger := slog.New(slog.NewTextHandler(logF, &slogOpts))
+	var logOut io.Writer = logF
+	if logW != nil {
+		logOut = logW
+	}
+	logger := slog.New(slog.NewTextHandler(logOut, &slogOpts))
 	slog.SetDefault(logger)
 
-	slog.Debug("startup", "colorProfile", lipgloss.DefaultRenderer().ColorProfile())
+	slog.Debug("startup", "colorProfile", lipgloss.DefaultRenderer().ColorProfile(),
+		"darkBackground", lipgloss.HasDarkBackground())
 
-	if *flagClassic {
-		mainClassic(cmd)
-	} else {
-		mainTea(cmd)
+	if len(*flagHTTP) > 0 {
+		if err := startStatusServer(*flagHTTP); err != nil {
+			printErrf("Cannot start HTTP server: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if *flagClassic && len(*flagControl) > 0 {
+		printErr("The control socket needs the TUI")
+		os.Exit(1)
+	}
+
+	if *flagOutFmt != formatText && *flagOutFmt != formatDiffJSON {
+		printErrf("Invalid output format %q (want %s or %s)", *flagOutFmt, formatText, formatDiffJSON)
+		os.Exit(1)
+	}
+
+	if *flagClassic && *flagOutFmt == formatDiffJSON {
+		printErr("Writing the diffs as JSON needs the TUI")
+		os.Exit(1)
+	}
+
+	if *flagOutFmt == formatDiffJSON && len(*flagOutFile) == 0 {
+		printErr("Writing the diffs as JSON needs --output-file")
+		os.Exit(1)
+	}
+
+	if *flagClassic && (len(*flagCSV) > 0 || len(*flagChart) > 0) {
+		printErr("Writing metrics to CSV or to a chart needs the TUI")
+		os.Exit(1)
+	}
+
+	if len(*flagServe) > 0 {
+		if err := startPageServer(*flagServe, strings.Join(cmd, " "), *flagInterval); err != nil {
+			printErrf("Cannot start HTTP server: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	switch {
+	case len(layout) > 0:
+		mainPanes(cmds, pipeline, layout)
+	case *flagClassic:
+		mainClassic(cmd, pipeline)
+	default:
+		mainTea(cmd, pipeline, rp)
+	}
+}
+
+// Environment variable telling whether to start the TUI in alt screen
+const envAlt = "A555WATCH_ALT"
+
+// Environment variable naming a file with the banner to show in the help
+const envBanner = "A555WATCH_BANNER"
+
+func hostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "n/a"
+	}
+	return host
+}
+
+func pagerCommand() string {
+	if len(strings.TrimSpace(*flagPager)) > 0 {
+		return *flagPager
+	}
+	if pager := os.Getenv("PAGER"); len(strings.TrimSpace(pager)) > 0 {
+		return pager
+	}
+	return "less"
+}
+
+// writeOutputFile writes s to the file given with --output-file, if any.
+func writeOutputFile(s string) {
+	if len(*flagOutFile) == 0 {
+		return
+	}
+	if err := os.WriteFile(*flagOutFile, []byte(s), 0o600); err != nil {
+		printErrf("Cannot write output file: %v", err)
+	}
+}
+
+// Backgrounds of the terminal to pick colors for
+const (
+	bgAuto  = "auto"
+	bgDark  = "dark"
+	bgLight = "light"
+)
+
+// How to clear the screen between runs in classic mode
+const (
+	clearANSI     = "ansi"
+	clearFormFeed = "form-feed"
+	clearNone     = "none"
+)
+
+func validateClearMode(mode string) error {
+	switch mode {
+	case "", clearANSI, clearFormFeed, clearNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid clear mode %q (want %s, %s or %s)", mode, clearANSI, clearFormFeed, clearNone)
 	}
 }
 
+// exitCode returns the exit status of a command given the error it returned.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return exitFailure
+}
+
 func printErr(s string)             { fmt.Fprintf(os.Stderr, "%s\n", errStyle.Render(s)) }
 func printErrf(f string, vs ...any) { printErr(fmt.Sprintf(f, vs...)) }
 
+// printExitReason tells why we exited early, unless asked to be quiet.
+func printExitReason(s string) {
+	if !*flagQuiet {
+		printErr(s)
+	}
+}
+
 func bool2String(v bool) string {
 	if v {
 		return "y"
@@ -917,6 +3135,40 @@ func bool2String(v bool) string {
 	return "n"
 }
 
+// duration2String formats d compactly, keeping only its two largest units.
+func duration2String(d time.Duration) string {
+	secs := int64(d / time.Second)
+	switch {
+	case secs < 60:
+		return fmt.Sprintf("%ds", secs)
+	case secs < 3600:
+		return fmt.Sprintf("%dm%02ds", secs/60, secs%60)
+	default:
+		return fmt.Sprintf("%dh%02dm", secs/3600, secs/60%60)
+	}
+}
+
+func bytes2String(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := unit, 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// delta2String renders a change with its sign, or n/a if unknown.
+func delta2String(v *int) string {
+	if v == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+d", *v)
+}
+
 func intp2String(v *int) string {
 	if v == nil {
 		return "n/a"
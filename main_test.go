@@ -0,0 +1,509 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/timer"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+func newTestModel() model {
+	m := newModel([]string{"true"}, outputPipeline{})
+	return update(m, tea.WindowSizeMsg{Width: 120, Height: 30})
+}
+
+func output(s string) cmdMsg {
+	return cmdMsg{out: cmdOutput{
+		stdout:   []byte(s),
+		stderr:   nil,
+		combined: []byte(s),
+		pid:      0,
+		usage:    nil,
+		dur:      0,
+		at:       time.Time{},
+	}, err: nil}
+}
+
+// update feeds msg to m. The commands it returns are left alone, e.g. timers.
+func update(m model, msg tea.Msg) model {
+	tm, _ := m.Update(msg)
+	m, _ = tm.(model)
+	return m
+}
+
+// step feeds msg to m, returning the commands as well.
+func step(m model, msg tea.Msg) (model, tea.Cmd) {
+	tm, cmd := m.Update(msg)
+	m, _ = tm.(model)
+	return m, cmd
+}
+
+// await runs cmd and any commands it batches until one of them returns a
+// message of type T.
+func await[T tea.Msg](t *testing.T, cmd tea.Cmd) T {
+	t.Helper()
+	msgs := make(chan tea.Msg)
+	done := make(chan struct{})
+	defer close(done)
+	var run func(tea.Cmd)
+	run = func(cmd tea.Cmd) {
+		if cmd == nil {
+			return
+		}
+		msg := cmd()
+		if batch, ok := msg.(tea.BatchMsg); ok {
+			for _, cmd := range batch {
+				go run(cmd)
+			}
+			return
+		}
+		select {
+		case msgs <- msg:
+		case <-done:
+		}
+	}
+	go run(cmd)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-msgs:
+			if v, ok := msg.(T); ok {
+				return v
+			}
+		case <-timeout:
+			var zero T
+			t.Fatalf("no %T message", zero)
+			return zero
+		}
+	}
+}
+
+// refilter feeds m the results of filtering the list, as the program does
+// once the command filtering it after a change ran.
+func refilter(m model) model {
+	if !m.filtering() {
+		return m
+	}
+	l := m.list
+	return update(m, l.SetItems(l.Items())())
+}
+
+func selected(t *testing.T, m model) listItem {
+	t.Helper()
+	li, ok := m.list.SelectedItem().(listItem)
+	if !ok {
+		t.Fatal("no entry selected")
+	}
+	return li
+}
+
+// shown is the entry shown in the pager, 0 for none as entries are numbered
+// from 1.
+func shown(m model) entryID {
+	if m.seleID == nil {
+		return 0
+	}
+	return *m.seleID
+}
+
+func newest(m model) listItem {
+	li, _ := m.list.Items()[0].(listItem)
+	return li
+}
+
+// filteredModel has entries of which only the long ones pass the filter.
+func filteredModel(t *testing.T) model {
+	t.Helper()
+	m := newTestModel()
+	for _, s := range []string{"aaaaaaa\n", "b\n", "ccccccc\n", "d\n"} {
+		m = update(m, output(s))
+	}
+	if _, err := m.applyMetricFilter("chars>3"); err != nil {
+		t.Fatal(err)
+	}
+	m = refilter(m)
+	if n := len(m.list.VisibleItems()); n != 2 {
+		t.Fatalf("filter shows %d entries, want 2", n)
+	}
+	return m
+}
+
+func TestInsertWhileFilteredTracksNewestMatch(t *testing.T) {
+	m := filteredModel(t)
+	// Following moves the selection from wherever it is
+	m.list.Select(1)
+	m.follow = true
+
+	m = refilter(update(m, output("eeeeeeeee\n")))
+	want := newest(m).id
+	if got := selected(t, m).id; got != want {
+		t.Errorf("selected entry %d, want the newest %d", got, want)
+	}
+	if got := shown(m); got != want {
+		t.Errorf("shown entry %d, want %d", got, want)
+	}
+
+	// Hidden by the filter, the selection stays on the newest match
+	m = refilter(update(m, output("f\n")))
+	if got := selected(t, m).id; got != want {
+		t.Errorf("selected entry %d, want the newest match %d", got, want)
+	}
+	if got := shown(m); got != want {
+		t.Errorf("shown entry %d, want %d", got, want)
+	}
+	if !m.follow {
+		t.Error("stopped following")
+	}
+	if n := len(m.list.VisibleItems()); n != 3 {
+		t.Errorf("filter shows %d entries, want 3", n)
+	}
+}
+
+func TestInsertWhileFilteredKeepsSelection(t *testing.T) {
+	m := filteredModel(t)
+	m.follow = false
+	m.list.Select(1)
+	m.switchContent()
+	want := selected(t, m).id
+
+	m = refilter(update(m, output("eeeeeeeee\n")))
+	if got := selected(t, m).id; got != want {
+		t.Errorf("selected entry %d, want %d as before", got, want)
+	}
+	if got := shown(m); got != want {
+		t.Errorf("shown entry %d, want %d", got, want)
+	}
+	if m.follow {
+		t.Error("started following")
+	}
+}
+
+func TestSwitchContentWithoutEntries(t *testing.T) {
+	m := newTestModel()
+	m.switchContent()
+	m.switchDiffContent()
+	if m.seleID != nil {
+		t.Errorf("shown entry %d without any", *m.seleID)
+	}
+	if got := m.pagerTitleView(); !strings.Contains(got, "waiting for first output") {
+		t.Errorf("pager title %q lacks the placeholder", got)
+	}
+}
+
+func TestSwitchContentWhenFilterMatchesNothing(t *testing.T) {
+	m := filteredModel(t)
+	if !strings.Contains(m.View(), "ccccccc") {
+		t.Fatal("newest match not shown")
+	}
+	if _, err := m.applyMetricFilter("chars>100"); err != nil {
+		t.Fatal(err)
+	}
+	m = refilter(m)
+	if n := len(m.list.VisibleItems()); n != 0 {
+		t.Fatalf("filter shows %d entries, want none", n)
+	}
+	m.switchContent()
+	m.switchDiffContent()
+	if m.seleID != nil {
+		t.Errorf("shown entry %d hidden by the filter", *m.seleID)
+	}
+	if got := m.pagerTitleView(); !strings.Contains(got, "no entry selected") {
+		t.Errorf("pager title %q lacks the placeholder", got)
+	}
+	if view := m.View(); strings.Contains(view, "ccccccc") {
+		t.Errorf("pager shows an entry hidden by the filter:\n%s", view)
+	}
+}
+
+func keyPress(s string) tea.KeyMsg {
+	switch s {
+	case "esc":
+		return tea.KeyMsg{Type: tea.KeyEsc, Runes: nil, Alt: false, Paste: false}
+	case "enter":
+		return tea.KeyMsg{Type: tea.KeyEnter, Runes: nil, Alt: false, Paste: false}
+	case "tab":
+		return tea.KeyMsg{Type: tea.KeyTab, Runes: nil, Alt: false, Paste: false}
+	default:
+		return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s), Alt: false, Paste: false}
+	}
+}
+
+func TestResumeAfterResetWhilePaused(t *testing.T) {
+	m := newTestModel()
+	m.interval = 10 * time.Millisecond
+	m = update(m, output("a\n"))
+	m = update(m, keyPress("p"))
+	if !m.paused {
+		t.Fatal("not paused")
+	}
+	m = update(m, keyPress("r"))
+	m, cmd := step(m, keyPress("p"))
+	if m.paused {
+		t.Fatal("still paused")
+	}
+	m, cmd = step(m, await[timer.StartStopMsg](t, cmd))
+	if !m.timer.Running() {
+		t.Fatal("countdown not running after resuming")
+	}
+	await[timer.TickMsg](t, cmd)
+}
+
+func TestClearFilterRestoresFollow(t *testing.T) {
+	clears := map[string][]string{
+		"clear":  {"esc"},
+		"cancel": {"/", "esc"},
+	}
+	for name, keys := range clears {
+		for _, follow := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s/follow=%v", name, follow), func(t *testing.T) {
+				m := newTestModel()
+				for _, s := range []string{"a\n", "b\n", "c\n"} {
+					m = update(m, output(s))
+				}
+				m.follow = follow
+				m = update(m, keyPress("tab"))
+				// Filter, then look at an older entry
+				m = update(m, keyPress("/"))
+				m = refilter(update(m, keyPress(":")))
+				m = update(m, keyPress("enter"))
+				m = update(m, keyPress("j"))
+				if !m.list.IsFiltered() {
+					t.Fatal("list not filtered")
+				}
+				if m.follow {
+					t.Fatal("following while looking at an older entry")
+				}
+				for _, k := range keys {
+					m = update(m, keyPress(k))
+				}
+				if m.filtering() {
+					t.Fatal("list still filtered")
+				}
+				if m.follow != follow {
+					t.Errorf("following is %v, want %v as before filtering", m.follow, follow)
+				}
+				if follow && selected(t, m).id != newest(m).id {
+					t.Errorf("selected entry %d, want the newest %d", selected(t, m).id, newest(m).id)
+				}
+
+				m = update(m, output("d\n"))
+				atNewest := selected(t, m).id == newest(m).id
+				if atNewest != follow {
+					t.Errorf("newest entry selected is %v, want %v", atNewest, follow)
+				}
+			})
+		}
+	}
+}
+
+func TestMinChange(t *testing.T) {
+	r := newRunner([]string{"true"}, outputPipeline{})
+	r.minChg = 2
+	prev := &[numStreams]string{streamOut: "abcdef", streamErr: "x", streamBoth: ""}
+	for _, tc := range []struct {
+		out, err string
+		want     bool
+	}{
+		{"abcdef", "x", false},
+		{"abXdef", "x", false},
+		{"abXdef", "y", false},
+		{"abXYef", "y", true},
+		{"abcdefghi", "x", true},
+	} {
+		text := [numStreams]string{streamOut: tc.out, streamErr: tc.err, streamBoth: ""}
+		if got := r.differs(prev, text); got != tc.want {
+			t.Errorf("%q, %q differs is %v, want %v", tc.out, tc.err, got, tc.want)
+		}
+	}
+	if !r.differs(nil, *prev) {
+		t.Error("first output does not differ")
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	r := newRunner([]string{"true"}, outputPipeline{})
+	r.debounce = time.Second
+	start := time.Now()
+	text := func(s string) [numStreams]string {
+		return [numStreams]string{streamOut: s, streamErr: "", streamBoth: s}
+	}
+	for _, tc := range []struct {
+		out   string
+		after time.Duration
+		want  bool
+	}{
+		{"a", 0, false},
+		{"a", 500 * time.Millisecond, false},
+		{"b", 600 * time.Millisecond, false},
+		{"b", 1500 * time.Millisecond, false},
+		{"b", 1600 * time.Millisecond, true},
+	} {
+		if got := r.settled(true, text(tc.out), start.Add(tc.after)); got != tc.want {
+			t.Errorf("%q after %v settled is %v, want %v", tc.out, tc.after, got, tc.want)
+		}
+	}
+	if r.pending != nil {
+		t.Error("change still pending once recorded")
+	}
+	r.settled(true, text("c"), start)
+	if got := r.next(time.Minute); got != r.debounce {
+		t.Errorf("next run in %v with a pending change, want %v", got, r.debounce)
+	}
+	if r.settled(false, text(""), start) || r.pending != nil {
+		t.Error("change still pending once back to the recorded output")
+	}
+	if got := r.next(time.Minute); got != time.Minute {
+		t.Errorf("next run in %v, want %v", got, time.Minute)
+	}
+}
+
+func TestDimmedLineStaysFaintAfterHighlight(t *testing.T) {
+	profile := lipgloss.ColorProfile()
+	lipgloss.SetColorProfile(termenv.ANSI)
+	t.Cleanup(func() { lipgloss.SetColorProfile(profile) })
+	res := highlightRes
+	highlightRes = []*regexp.Regexp{regexp.MustCompile("needle")}
+	t.Cleanup(func() { highlightRes = res })
+
+	diffs := []diffmatchpatch.Diff{{Type: diffmatchpatch.DiffEqual, Text: "hay needle stack\n"}}
+	got := renderLineDiff(diffs, unchangedDim).text
+	for _, s := range []string{"hay ", " stack"} {
+		if !strings.Contains(got, diffDimStyle.Render(s)) {
+			t.Errorf("%q not faint in %q", s, got)
+		}
+	}
+}
+
+func TestRenderChart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chart.svg")
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	xs := []time.Time{start, start.Add(time.Minute), start.Add(2 * time.Minute)}
+	if err := renderChart(path, xs, []float64{10, 40, 20}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var svg struct {
+		Polyline struct {
+			Points string `xml:"points,attr"`
+		} `xml:"polyline"`
+	}
+	if err := xml.Unmarshal(b, &svg); err != nil {
+		t.Fatalf("invalid SVG: %v", err)
+	}
+	want := "60.0,270.0 400.0,60.0 740.0,200.0"
+	if svg.Polyline.Points != want {
+		t.Errorf("points %q, want %q", svg.Polyline.Points, want)
+	}
+	if !strings.Contains(string(b), "03:04:05") || !strings.Contains(string(b), "03:06:05") {
+		t.Errorf("chart lacks the times of the first and last outputs:\n%s", b)
+	}
+}
+
+func TestRecordFramesOnUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tui.cast")
+	rec, err := newRecorder(path, 120, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newTestModel()
+	m.rec = rec
+	defer rec.Close()
+	cast := func() []string {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+
+	m = update(m, output("recorded\n"))
+	// The header, then the frame with the output
+	lines := cast()
+	if len(lines) != 2 || !strings.Contains(lines[1], "recorded") {
+		t.Fatalf("cast has %d lines, want the header and one frame", len(lines))
+	}
+	// Rendering records nothing
+	m.elapsed = 0.5
+	_ = m.View()
+	if n := len(cast()); n != 2 {
+		t.Errorf("cast has %d lines after rendering, want 2", n)
+	}
+}
+
+func TestMetricsFileWrittenByCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.prom")
+	flagPrev := *flagMetrics
+	*flagMetrics = path
+	t.Cleanup(func() { *flagMetrics = flagPrev })
+
+	_, cmd := step(newTestModel(), output("a\n"))
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("metrics file written while updating: %v", err)
+	}
+	batch, ok := cmd().(tea.BatchMsg)
+	if !ok {
+		t.Fatal("no batch of commands")
+	}
+	for _, cmd := range batch {
+		if cmd != nil {
+			go cmd()
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if b, err := os.ReadFile(path); err == nil {
+			if !strings.Contains(string(b), "a555watch_runs_total 1") {
+				t.Errorf("metrics file lacks the run:\n%s", b)
+			}
+			return
+		}
+	}
+	t.Error("metrics file not written")
+}
+
+func TestLogErrorReachesAllPanes(t *testing.T) {
+	p := newPanes([][]string{{"true"}, {"true"}}, outputPipeline{}, layoutTabs)
+	tm, _ := p.Update(logErrMsg{errors.New("disk full")})
+	p, _ = tm.(panes)
+	for i, m := range p.models {
+		if !m.logFail {
+			t.Errorf("pane %d not told about the log error", i)
+		}
+	}
+}
+
+func TestListenControlOverStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenControl(path, nil); err == nil {
+		t.Error("listening on a socket in use")
+	}
+	// Left behind as if killed
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	ln.Close()
+
+	ln, err = listenControl(path, nil)
+	if err != nil {
+		t.Fatalf("cannot listen over a stale socket: %v", err)
+	}
+	ln.Close()
+}
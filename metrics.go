@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// runMetrics are the figures written to the file given with --metrics-file.
+type runMetrics struct {
+	runs, changes int
+	exitCode      int
+	outBytes      int
+	duration      time.Duration
+}
+
+// prometheus renders the metrics in the Prometheus text exposition format.
+func (rm runMetrics) prometheus() string {
+	var sb strings.Builder
+	metric := func(name, typ, help string, v any) {
+		fmt.Fprintf(&sb, "# HELP a555watch_%s %s\n# TYPE a555watch_%s %s\na555watch_%s %v\n",
+			name, help, name, typ, name, v)
+	}
+	metric("runs_total", "counter", "Number of completed runs of the command.", rm.runs)
+	metric("changes_total", "counter", "Number of runs whose output changed.", rm.changes)
+	metric("last_exit_code", "gauge", "Exit code of the last run.", rm.exitCode)
+	metric("last_output_bytes", "gauge", "Size of the output of the last run.", rm.outBytes)
+	metric("last_run_duration_seconds", "gauge", "How long the last run took.", rm.duration.Seconds())
+	return sb.String()
+}
+
+var (
+	lastMetricsMu sync.Mutex
+	lastMetrics   *runMetrics
+)
+
+// publishMetrics makes the metrics of the latest run available to the status
+// server. The metrics file is written apart, see writeMetricsFile.
+func publishMetrics(rm runMetrics) {
+	lastMetricsMu.Lock()
+	lastMetrics = &rm
+	lastMetricsMu.Unlock()
+}
+
+// latestMetrics returns the metrics of the latest run, if any.
+func latestMetrics() (runMetrics, bool) {
+	lastMetricsMu.Lock()
+	defer lastMetricsMu.Unlock()
+	if lastMetrics == nil {
+		return runMetrics{runs: 0, changes: 0, exitCode: 0, outBytes: 0, duration: 0}, false
+	}
+	return *lastMetrics, true
+}
+
+// writeMetrics writes the metrics file, if any, without holding up the TUI.
+func writeMetrics(rm runMetrics) tea.Cmd {
+	path := *flagMetrics
+	if len(path) == 0 {
+		return nil
+	}
+	return func() tea.Msg {
+		writeMetricsFile(path, rm)
+		return nil
+	}
+}
+
+// writeMetricsFile replaces the file at path, the one given with
+// --metrics-file if any, with the metrics. The file is renamed into place so
+// that scrapers never see it half written.
+func writeMetricsFile(path string, rm runMetrics) {
+	if len(path) == 0 {
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".a555watch-metrics-*")
+	if err != nil {
+		slog.Warn("Cannot write metrics file", "err", err)
+		return
+	}
+	_, err = f.WriteString(rm.prometheus())
+	if err == nil {
+		err = f.Chmod(0o644) //nolint:gosec // Read by the metrics collector
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		slog.Warn("Cannot write metrics file", "err", err)
+		_ = os.Remove(f.Name())
+	}
+}
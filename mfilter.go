@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Metrics which can be used in metric filters
+var metricNames = []string{"chars", "lines", "lev", "add", "del"}
+
+// Separates the title of a list item from its metrics in its filter value
+const filterValueSep = "\x1f"
+
+// metricFilter filters the history list by the metrics of its entries, e.g.
+// "lev>50,lines>=10". Every condition has to hold for an entry to be shown.
+type metricFilter struct {
+	expr  string
+	conds []metricCond
+}
+
+type metricCond struct {
+	metric string
+	op     string
+	value  int
+}
+
+var metricCondRe = regexp.MustCompile(`^\s*([a-z]+)\s*(>=|<=|!=|==|=|>|<)\s*(\d+)\s*$`)
+
+func parseMetricFilter(expr string) (*metricFilter, error) {
+	f := metricFilter{expr: expr, conds: nil}
+	for cond := range strings.SplitSeq(expr, ",") {
+		match := metricCondRe.FindStringSubmatch(cond)
+		if match == nil {
+			return nil, fmt.Errorf("invalid condition %q (want e.g. lev>50)", cond)
+		}
+		if !slices.Contains(metricNames, match[1]) {
+			return nil, fmt.Errorf("unknown metric %q (want one of %s)", match[1], strings.Join(metricNames, ", "))
+		}
+		value, err := strconv.Atoi(match[3])
+		if err != nil {
+			return nil, err
+		}
+		f.conds = append(f.conds, metricCond{metric: match[1], op: match[2], value: value})
+	}
+	if len(f.conds) == 0 {
+		return nil, errors.New("empty metric filter")
+	}
+	return &f, nil
+}
+
+// match tells whether the metrics satisfy the filter. Unknown metrics never do.
+func (f *metricFilter) match(metrics map[string]int) bool {
+	for _, c := range f.conds {
+		v, ok := metrics[c.metric]
+		if !ok || v < 0 {
+			return false
+		}
+		var holds bool
+		switch c.op {
+		case ">":
+			holds = v > c.value
+		case ">=":
+			holds = v >= c.value
+		case "<":
+			holds = v < c.value
+		case "<=":
+			holds = v <= c.value
+		case "=", "==":
+			holds = v == c.value
+		case "!=":
+			holds = v != c.value
+		}
+		if !holds {
+			return false
+		}
+	}
+	return true
+}
+
+// metricsValue encodes the metrics of the item, unknown ones as -1.
+func (i listItem) metricsValue() string {
+	intp := func(v *int) int {
+		if v == nil {
+			return -1
+		}
+		return *v
+	}
+	return fmt.Sprintf("chars=%d lines=%d lev=%d add=%d del=%d",
+		i.nChars, i.nLines, intp(i.levDist), intp(i.additions), intp(i.deletions))
+}
+
+func parseMetricsValue(s string) map[string]int {
+	metrics := make(map[string]int, len(metricNames))
+	for _, kv := range strings.Fields(s) {
+		k, v, _ := strings.Cut(kv, "=")
+		if n, err := strconv.Atoi(v); err == nil {
+			metrics[k] = n
+		}
+	}
+	return metrics
+}
+
+// listFilter is the filter function of the history list. It is shared by
+// every copy of the model, so that the list sees which metric filter is active.
+type listFilter struct {
+	metric *metricFilter
+}
+
+func (lf *listFilter) filter(term string, targets []string) []list.Rank {
+	if lf.metric == nil {
+		titles := make([]string, len(targets))
+		for i, target := range targets {
+			titles[i], _, _ = strings.Cut(target, filterValueSep)
+		}
+		return list.UnsortedFilter(term, titles)
+	}
+	var ranks []list.Rank
+	for i, target := range targets {
+		_, metrics, _ := strings.Cut(target, filterValueSep)
+		if lf.metric.match(parseMetricsValue(metrics)) {
+			ranks = append(ranks, list.Rank{Index: i, MatchedIndexes: nil})
+		}
+	}
+	return ranks
+}
+
+// applyMetricFilter filters the history list by expr, computing the diff
+// metrics of the entries that lack them.
+func (m *model) applyMetricFilter(expr string) (tea.Cmd, error) {
+	mf, err := parseMetricFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("Applying metric filter", "expr", expr)
+	m.snapshotFollow()
+	m.lfilter.metric = nil
+	m.list.ResetFilter()
+	cmd := m.computeMetrics()
+	m.lfilter.metric = mf
+	m.list.SetFilterText(expr)
+	m.follow = false
+	return tea.Batch(cmd, m.switchContent()), nil
+}
+
+// significantExpr is the metric filter keeping only the significant changes.
+func (m model) significantExpr() string {
+	return fmt.Sprintf("lev>%d", m.signif)
+}
+
+// significantOnly tells whether the list only shows the significant changes.
+func (m model) significantOnly() bool {
+	return m.lfilter.metric != nil && m.lfilter.metric.expr == m.significantExpr()
+}
+
+// computeMetrics computes the diff metrics of the list items lacking them.
+func (m *model) computeMetrics() tea.Cmd {
+	var cmds []tea.Cmd
+	for i, item := range m.list.Items() {
+		li, ok := item.(listItem)
+		if !ok || li.levDist != nil {
+			continue
+		}
+		h := m.hist[li.id]
+		if h.prevID == nil {
+			continue
+		}
+		prev, cur := m.hist[*h.prevID].text(m.stream), h.text(m.stream)
+		if m.diff == diffChar {
+			li.update(m.dmp, m.charDiffs(prev, cur), false)
+		} else {
+			li.update(m.dmp, m.lineDiffs(prev, cur), m.cntLines)
+		}
+		cmds = append(cmds, m.list.SetItem(i, li))
+	}
+	return tea.Batch(cmds...)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	binaryAuto = "auto"
+	binaryHex  = "hex"
+	binaryRaw  = "raw"
+)
+
+func validateBinaryMode(mode string) error {
+	switch mode {
+	case binaryAuto, binaryHex, binaryRaw:
+		return nil
+	default:
+		return fmt.Errorf("invalid binary mode %q (want %s, %s or %s)", mode, binaryAuto, binaryHex, binaryRaw)
+	}
+}
+
+// decodeOutput turns the raw output of a command into the text to display,
+// rendering it as a hexdump according to the binary mode.
+func decodeOutput(out []byte, mode string) string {
+	if mode == binaryHex || (mode == binaryAuto && isBinary(out)) {
+		return hex.Dump(out)
+	}
+	return string(out)
+}
+
+// isBinary guesses whether out is binary data: either it contains NUL bytes
+// or more than a tenth of it is not valid UTF-8.
+func isBinary(out []byte) bool {
+	if bytes.IndexByte(out, 0) >= 0 {
+		return true
+	}
+	var total, invalid int
+	for len(out) > 0 {
+		r, size := utf8.DecodeRune(out)
+		if r == utf8.RuneError && size == 1 {
+			invalid++
+		}
+		total++
+		out = out[size:]
+	}
+	return invalid*10 > total
+}
+
+// cmdOutput holds what a command wrote to its standard streams. The combined
+// output interleaves the two streams roughly in the order they were written.
+type cmdOutput struct {
+	stdout, stderr, combined []byte
+	// PID the command ran with
+	pid int
+	// Resources used by the command, if known
+	usage *resUsage
+	// How long the command took to run
+	dur time.Duration
+	// When the output was produced, if it is not fresh
+	at time.Time
+}
+
+// resUsage is the CPU time and peak memory used by a command.
+type resUsage struct {
+	user, sys time.Duration
+	maxRSS    int64
+}
+
+// runCaptured runs c capturing its stdout, stderr and their combination.
+func runCaptured(c *exec.Cmd) (cmdOutput, error) {
+	var (
+		stdout, stderr bytes.Buffer
+		combined       lockedBuffer
+	)
+	c.Stdout = io.MultiWriter(&stdout, &combined)
+	c.Stderr = io.MultiWriter(&stderr, &combined)
+	err := c.Run()
+	return cmdOutput{stdout: stdout.Bytes(), stderr: stderr.Bytes(), combined: combined.buf.Bytes(), pid: 0, usage: nil, dur: 0, at: time.Time{}}, err
+}
+
+// lockedBuffer is a bytes.Buffer safe to be written from multiple goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// outputPipeline normalizes the output of a command before it is compared
+// and displayed.
+type outputPipeline struct {
+	include, exclude []*regexp.Regexp
+	transform        *template.Template
+	pretty           bool
+	sort, unique     bool
+}
+
+func newOutputPipeline() (outputPipeline, error) {
+	var (
+		p = outputPipeline{
+			include:   nil,
+			exclude:   nil,
+			transform: nil,
+			pretty:    *flagJSON,
+			sort:      *flagSort,
+			unique:    *flagUnique,
+		}
+		err error
+	)
+	if p.include, err = compileRegexps(*flagInclude); err != nil {
+		return p, fmt.Errorf("invalid include pattern: %w", err)
+	}
+	if p.exclude, err = compileRegexps(*flagExclude); err != nil {
+		return p, fmt.Errorf("invalid exclude pattern: %w", err)
+	}
+	if len(*flagTemplate) > 0 {
+		funcs := template.FuncMap{"json": transformJSON}
+		if p.transform, err = template.New("transform").Funcs(funcs).Parse(*flagTemplate); err != nil {
+			return p, fmt.Errorf("invalid transform: %w", err)
+		}
+	}
+	return p, nil
+}
+
+func compileRegexps(exprs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// active tells whether the pipeline changes the output at all.
+func (p outputPipeline) active() bool {
+	return len(p.include) > 0 || len(p.exclude) > 0 || p.transform != nil || p.pretty ||
+		p.sort || p.unique
+}
+
+// apply normalizes the output of the given stream. Transformations of the
+// whole output only apply to stdout, which they expect to be JSON.
+func (p outputPipeline) apply(s string, stream outStream) string {
+	if !p.active() {
+		return s
+	}
+	if p.transform != nil && stream == streamOut {
+		s = p.applyTransform(s)
+	}
+	if p.pretty && stream == streamOut {
+		s = prettyJSON(s)
+	}
+	var lines []string
+	for line := range strings.Lines(s) {
+		line = strings.TrimSuffix(line, "\n")
+		if p.keepLine(line) {
+			lines = append(lines, line)
+		}
+	}
+	if p.sort {
+		slices.Sort(lines)
+	}
+	if p.unique {
+		lines = uniqueLines(lines)
+	}
+	out := strings.Join(lines, "\n")
+	if len(lines) > 0 && strings.HasSuffix(s, "\n") {
+		out += "\n"
+	}
+	return out
+}
+
+// uniqueLines drops the repeated lines, keeping the first occurrence of each.
+func uniqueLines(lines []string) []string {
+	seen := make(map[string]struct{}, len(lines))
+	return slices.DeleteFunc(lines, func(line string) bool {
+		if _, ok := seen[line]; ok {
+			return true
+		}
+		seen[line] = struct{}{}
+		return false
+	})
+}
+
+// keepLine tells whether the line matches any include pattern, if there are
+// any, and no exclude pattern.
+func (p outputPipeline) keepLine(line string) bool {
+	matches := func(re *regexp.Regexp) bool { return re.MatchString(line) }
+	if len(p.include) > 0 && !slices.ContainsFunc(p.include, matches) {
+		return false
+	}
+	return !slices.ContainsFunc(p.exclude, matches)
+}
+
+// applyTransform executes the transform template with the JSON output s as
+// data. Failures are reported in place of the output.
+func (p outputPipeline) applyTransform(s string) string {
+	var data any
+	if err := json.Unmarshal([]byte(s), &data); err != nil {
+		return fmt.Sprintf("a555watch: cannot transform output, not valid JSON: %v\n", err)
+	}
+	var sb strings.Builder
+	if err := p.transform.Execute(&sb, data); err != nil {
+		return fmt.Sprintf("a555watch: cannot transform output: %v\n", err)
+	}
+	return sb.String()
+}
+
+func transformJSON(v any) (string, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	return string(data), err
+}
+
+// prettyJSON indents s with its object keys sorted, so that equivalent
+// documents compare equal line by line. Output that is not a single JSON
+// value is returned unchanged.
+func prettyJSON(s string) string {
+	var (
+		data any
+		dec  = json.NewDecoder(strings.NewReader(s))
+	)
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return s
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return s
+	}
+	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		return s
+	}
+	return sb.String()
+}
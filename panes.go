@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Options which only make sense when watching a single command
+var singleCommandFlags = []string{
+	"replay", "record", "save", "csv", "chart", "output-file", "serve", "metrics-file", "http",
+	"until-file", "until-port",
+}
+
+// splitCommands splits args into the commands separated by "--".
+func splitCommands(args []string) [][]string {
+	var cmds [][]string
+	for {
+		i := slices.Index(args, "--")
+		if i < 0 {
+			break
+		}
+		cmds = append(cmds, args[:i])
+		args = args[i+1:]
+	}
+	return append(cmds, args)
+}
+
+// paneMsg is a message for the pane at index i.
+type paneMsg struct {
+	i   int
+	msg tea.Msg
+}
+
+// tagCmd makes the messages of cmd reach the pane at index i. Those of the
+// runtime itself are left alone.
+func tagCmd(i int, cmd tea.Cmd) tea.Cmd {
+	if cmd == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		msg := cmd()
+		switch msg := msg.(type) {
+		case nil:
+			return nil
+		case tea.BatchMsg:
+			cmds := make(tea.BatchMsg, 0, len(msg))
+			for _, c := range msg {
+				cmds = append(cmds, tagCmd(i, c))
+			}
+			return cmds
+		}
+		if reflect.TypeOf(msg).PkgPath() == reflect.TypeOf(tea.QuitMsg{}).PkgPath() {
+			return msg
+		}
+		return paneMsg{i: i, msg: msg}
+	}
+}
+
+// Key switching the focus between panes, the focus within a pane moves to
+// switchPaneFocusKey instead
+const (
+	nextPaneKey        = "tab"
+	switchPaneFocusKey = "shift+tab"
+)
+
+var (
+	paneBarStyle        = lipgloss.NewStyle().Foreground(colorDark)
+	paneBarFocusedStyle = lipgloss.NewStyle().Foreground(colorPink).Bold(true)
+	tabStyle            = lipgloss.NewStyle().Foreground(colorPurple).Padding(0, 1)
+	tabActiveStyle      = tabStyle.Foreground(colorPink).Background(colorDark).Bold(true)
+	tabChangedStyle     = tabStyle.Foreground(colorPink)
+)
+
+// How the panes are laid out
+const (
+	layoutSplit = "split"
+	layoutTabs  = "tabs"
+)
+
+// panes watches several commands, each in its own model, either side by side
+// or in tabs of which only the focussed one is shown.
+type panes struct {
+	layout   string
+	models   []model
+	focus    int
+	nextPane key.Binding
+	// Which panes changed since they were last focussed
+	unseen []bool
+	width  int
+	height int
+}
+
+func newPanes(cmds [][]string, pipeline outputPipeline, layout string) panes {
+	p := panes{
+		layout:   layout,
+		models:   make([]model, 0, len(cmds)),
+		focus:    0,
+		nextPane: key.NewBinding(key.WithKeys(nextPaneKey)),
+		unseen:   make([]bool, len(cmds)),
+		width:    0,
+		height:   0,
+	}
+	// Shared by the panes, so that only so many of them run at once
+	var slots chan struct{}
+	if *flagParallel > 0 {
+		slots = make(chan struct{}, *flagParallel)
+	}
+	for _, cmd := range cmds {
+		m := newModel(cmd, pipeline)
+		m.runner.slots = slots
+		m.keys.switchFocus.SetKeys(switchPaneFocusKey)
+		m.setFocusHelp(switchFocusDescPager)
+		p.models = append(p.models, m)
+	}
+	return p
+}
+
+func (p panes) Init() tea.Cmd {
+	cmds := make([]tea.Cmd, 0, len(p.models))
+	for i, m := range p.models {
+		cmds = append(cmds, tagCmd(i, m.Init()))
+	}
+	return tea.Batch(cmds...)
+}
+
+func (p panes) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case paneMsg:
+		return p, p.updatePane(msg.i, msg.msg)
+
+	case tea.WindowSizeMsg:
+		p.width, p.height = msg.Width, msg.Height
+		cmds := make([]tea.Cmd, 0, len(p.models))
+		for i := range p.models {
+			cmds = append(cmds, p.updatePane(i, p.paneSize(i)))
+		}
+		return p, tea.Batch(cmds...)
+
+	case tea.FocusMsg, tea.BlurMsg, controlMsg, logErrMsg:
+		cmds := make([]tea.Cmd, 0, len(p.models))
+		for i := range p.models {
+			cmds = append(cmds, p.updatePane(i, msg))
+		}
+		return p, tea.Batch(cmds...)
+
+	case tea.KeyMsg:
+		if key.Matches(msg, p.nextPane) && !p.models[p.focus].capturesKeys() {
+			p.focus = (p.focus + 1) % len(p.models)
+			p.unseen[p.focus] = false
+			return p, nil
+		}
+	}
+	// Keys and the outcome of commands run by the focussed pane, e.g. the pager
+	return p, p.updatePane(p.focus, msg)
+}
+
+func (p *panes) updatePane(i int, msg tea.Msg) tea.Cmd {
+	changes := p.models[i].changes
+	tm, cmd := p.models[i].Update(msg)
+	if m, ok := tm.(model); ok {
+		p.models[i] = m
+	}
+	if i != p.focus && p.models[i].changes > changes {
+		p.unseen[i] = true
+	}
+	return tagCmd(i, cmd)
+}
+
+// paneSize is the size of the pane at index i. Panes side by side share the
+// width, and every layout leaves a line for the bar above the panes.
+func (p panes) paneSize(i int) tea.WindowSizeMsg {
+	n := len(p.models)
+	width := p.width / n
+	if p.layout == layoutTabs {
+		width = p.width
+	} else if i == n-1 {
+		width = p.width - width*(n-1)
+	}
+	return tea.WindowSizeMsg{Width: width, Height: max(p.height-1, 0)}
+}
+
+func (p panes) View() string {
+	if p.layout == layoutTabs {
+		return lipgloss.JoinVertical(lipgloss.Left, p.tabsView(), p.models[p.focus].View())
+	}
+
+	views := make([]string, 0, len(p.models))
+	for i, m := range p.models {
+		width := p.paneSize(i).Width
+		style := paneBarStyle
+		if i == p.focus {
+			style = paneBarFocusedStyle
+		}
+		label := fmt.Sprintf("━ %d ", i+1)
+		bar := style.Render(label + strings.Repeat("━", max(width-lipgloss.Width(label), 0)))
+		views = append(views, lipgloss.JoinVertical(lipgloss.Left, bar, m.View()))
+	}
+	return lipgloss.JoinHorizontal(lipgloss.Top, views...)
+}
+
+// tabsView renders a tab for each command, marking those whose output
+// changed since they were last shown.
+func (p panes) tabsView() string {
+	tabs := make([]string, 0, len(p.models))
+	for i, m := range p.models {
+		label := fmt.Sprintf("%d %s", i+1, strings.Join(m.cmd, " "))
+		switch {
+		case i == p.focus:
+			tabs = append(tabs, tabActiveStyle.Render(label))
+		case p.unseen[i]:
+			tabs = append(tabs, tabChangedStyle.Render("● "+label))
+		default:
+			tabs = append(tabs, tabStyle.Render(label))
+		}
+	}
+	bar := lipgloss.JoinHorizontal(lipgloss.Top, tabs...)
+	return lipgloss.NewStyle().MaxWidth(p.width).Render(bar)
+}
+
+// rc is the exit code of the first pane which set one.
+func (p panes) rc() int {
+	for _, m := range p.models {
+		if m.rc != 0 {
+			return m.rc
+		}
+	}
+	return 0
+}
+
+func mainPanes(cmds [][]string, pipeline outputPipeline, layout string) {
+	tm, err := runProgram(newPanes(cmds, pipeline, layout))
+	if errors.Is(err, tea.ErrInterrupted) {
+		os.Exit(128 + int(syscall.SIGINT))
+	}
+	if err != nil {
+		printErrf("Oops! %v", err)
+		os.Exit(exitFailure)
+	}
+	if p, ok := tm.(panes); ok && p.rc() != 0 {
+		os.Exit(p.rc())
+	}
+}
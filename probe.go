@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Conditions which can be waited for in place of running a command
+const (
+	probeFile = "file"
+	probePort = "port"
+)
+
+// How long to wait for a connection to a port
+const probeTimeout = time.Second
+
+// errPending is returned by probes whose condition does not hold yet.
+var errPending = errors.New("condition not met")
+
+// probe checks a condition in place of running a command, so that the rest of
+// a555watch treats it as any other command which fails until it holds.
+type probe struct {
+	kind, target string
+}
+
+// newProbe returns the probe given by the options, if any.
+func newProbe() *probe {
+	switch {
+	case len(*flagWaitFile) > 0:
+		return &probe{kind: probeFile, target: *flagWaitFile}
+	case len(*flagWaitPort) > 0:
+		return &probe{kind: probePort, target: *flagWaitPort}
+	default:
+		return nil
+	}
+}
+
+// argv describes the probe as if it was a command.
+func (p probe) argv() []string {
+	return []string{"until", p.kind, p.target}
+}
+
+func (p probe) check() (cmdOutput, error) {
+	start := time.Now()
+	var (
+		err      error
+		ok, fail string
+	)
+	switch p.kind {
+	case probeFile:
+		_, err = os.Stat(p.target)
+		ok, fail = "exists", "does not exist"
+	case probePort:
+		var conn net.Conn
+		if conn, err = net.DialTimeout("tcp", p.target, probeTimeout); err == nil {
+			conn.Close()
+		}
+		ok, fail = "is open", "is not open"
+	}
+	out := cmdOutput{stdout: nil, stderr: nil, combined: nil, pid: 0, usage: nil, dur: 0, at: time.Time{}}
+	if err != nil {
+		out.stdout = fmt.Appendf(nil, "%s %s %s\n", p.kind, p.target, fail)
+		out.stderr = fmt.Appendf(nil, "%v\n", err)
+		err = fmt.Errorf("%w: %w", errPending, err)
+	} else {
+		out.stdout = fmt.Appendf(nil, "%s %s %s\n", p.kind, p.target, ok)
+	}
+	out.combined = append(append([]byte{}, out.stdout...), out.stderr...)
+	out.dur = time.Since(start)
+	return out, err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// recorder writes the frames of the TUI to an asciinema v2 cast file.
+type recorder struct {
+	f     *os.File
+	w     *bufio.Writer
+	start time.Time
+	// Last frame and terminal size written
+	last          string
+	width, height int
+	err           error
+}
+
+func newRecorder(path string, width, height int) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r := &recorder{
+		f:      f,
+		w:      bufio.NewWriter(f),
+		start:  time.Now(),
+		last:   "",
+		width:  width,
+		height: height,
+		err:    nil,
+	}
+	header := map[string]any{
+		"version":   2,
+		"width":     width,
+		"height":    height,
+		"timestamp": r.start.Unix(),
+		"env":       map[string]string{"TERM": os.Getenv("TERM")},
+	}
+	if err := r.writeLine(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// frame records the view, unless it did not change since the last frame.
+func (r *recorder) frame(view string, width, height int) {
+	// Nothing sensible is shown before knowing the terminal size
+	if r.err != nil || view == r.last || width == 0 || height == 0 {
+		return
+	}
+	t := time.Since(r.start).Seconds()
+	if width != r.width || height != r.height {
+		r.width, r.height = width, height
+		r.event(t, "r", fmt.Sprintf("%dx%d", width, height))
+	}
+	r.last = view
+	r.event(t, "o", "\x1b[H\x1b[2J"+strings.ReplaceAll(view, "\n", "\r\n"))
+}
+
+func (r *recorder) event(t float64, typ, data string) {
+	if r.err != nil {
+		return
+	}
+	if r.err = r.writeLine([]any{t, typ, data}); r.err != nil {
+		slog.Warn("Cannot record frame, stopping the recording", "err", r.err)
+	}
+}
+
+func (r *recorder) writeLine(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := r.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	// Flush every line, so that the cast is usable even if we get killed
+	return r.w.Flush()
+}
+
+func (r *recorder) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// replay feeds the entries of a saved session back as if the command
+// produced them, keeping their timing.
+type replay struct {
+	entries []sessionEntry
+	// Index of the next entry to feed
+	next  int
+	speed float64
+	// Entries of the session left out, the oldest ones
+	skipped int
+}
+
+// loadReplay reads the session saved in path with --save. With a tail, only
+// the last tail entries are kept and the oldest of them is fed first, as if
+// the watch had started there.
+func loadReplay(path string, speed float64, tail int) (*replay, session, error) {
+	var s session
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, s, err
+	}
+	if len(s.Command) == 0 || len(s.Entries) == 0 {
+		return nil, s, errors.New("no command or output in session")
+	}
+	skipped := 0
+	if tail > 0 && tail < len(s.Entries) {
+		skipped = len(s.Entries) - tail
+	}
+	return &replay{entries: s.Entries[skipped:], next: 0, speed: speed, skipped: skipped}, s, nil
+}
+
+// tailView tells how many entries of the session are replayed.
+func (r *replay) tailView() string {
+	return fmt.Sprintf("last %d of %d", len(r.entries), len(r.entries)+r.skipped)
+}
+
+// done tells whether every entry was fed.
+func (r *replay) done() bool {
+	return r.next >= len(r.entries)
+}
+
+// output returns the next entry as the output of a run.
+func (r *replay) output() cmdMsg {
+	e := r.entries[r.next]
+	out := cmdOutput{
+		stdout:   []byte(e.Stdout),
+		stderr:   []byte(e.Stderr),
+		combined: []byte(e.Combined),
+		pid:      0,
+		usage:    nil,
+		dur:      0,
+		at:       e.Time,
+	}
+	return cmdMsg{out, nil}
+}
+
+// wait returns how long to wait before feeding the next entry, scaled by the
+// replay speed.
+func (r *replay) wait() time.Duration {
+	if r.next == 0 || r.done() {
+		return 0
+	}
+	gap := r.entries[r.next].Time.Sub(r.entries[r.next-1].Time)
+	return time.Duration(float64(gap) / r.speed)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"time"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// runner runs the watched command and makes sense of its output. Both the TUI
+// and the classic mode use it, so that they behave the same.
+type runner struct {
+	argv     []string
+	opts     runOpts
+	pipeline outputPipeline
+	binary   string
+	errExit  bool
+	chgExit  bool
+	chgCode  int
+	// Changes of at most this many characters are ignored
+	minChg int
+	dmp    *diffmatchpatch.DiffMatchPatch
+	// Changes are recorded once the output stayed the same this long
+	debounce time.Duration
+	// Changed output waiting to be stable for the debounce, and since when
+	pending *[numStreams]string
+	pendAt  time.Time
+	// Condition checked in place of running argv, if any
+	probe *probe
+	// Limits how many commands run at once, if not nil
+	slots chan struct{}
+}
+
+func newRunner(argv []string, pipeline outputPipeline) runner {
+	return runner{
+		argv:     argv,
+		opts:     newRunOpts(),
+		pipeline: pipeline,
+		binary:   *flagBinary,
+		errExit:  *flagErrExit,
+		chgExit:  *flagChgExit,
+		chgCode:  *flagChgCode,
+		minChg:   *flagMinChg,
+		dmp:      newDiffMatchPatch(),
+		debounce: *flagDebounce,
+		pending:  nil,
+		pendAt:   time.Time{},
+		probe:    newProbe(),
+		slots:    nil,
+	}
+}
+
+// exec runs the command once, waiting for a free slot first if they are limited.
+func (r runner) exec(width, height int) (cmdOutput, error) {
+	if r.probe != nil {
+		return r.probe.check()
+	}
+	if r.slots != nil {
+		r.slots <- struct{}{}
+		defer func() { <-r.slots }()
+	}
+	return r.opts.exec(r.argv, width, height)
+}
+
+// normalize decodes the output of a run and passes it through the pipeline.
+// It returns both the decoded and the normalized text of each stream.
+func (r runner) normalize(out cmdOutput) (raw, text [numStreams]string) {
+	raw = [numStreams]string{
+		streamOut:  decodeOutput(out.stdout, r.binary),
+		streamErr:  decodeOutput(out.stderr, r.binary),
+		streamBoth: decodeOutput(out.combined, r.binary),
+	}
+	for s := range numStreams {
+		text[s] = r.pipeline.apply(raw[s], s)
+	}
+	return raw, text
+}
+
+// changed tells whether the normalized output differs from the previous one,
+// if any. Only stdout and stderr are compared, their combination follows.
+func changed(prev *[numStreams]string, text [numStreams]string) bool {
+	return prev == nil || prev[streamOut] != text[streamOut] || prev[streamErr] != text[streamErr]
+}
+
+// differs tells whether the normalized output changed from the previous one,
+// if any, by more than the minimum change.
+func (r runner) differs(prev *[numStreams]string, text [numStreams]string) bool {
+	if !changed(prev, text) {
+		return false
+	}
+	if prev == nil || r.minChg == 0 {
+		return true
+	}
+	// Small changes are as good as none
+	dist := 0
+	for _, s := range []outStream{streamOut, streamErr} {
+		dist += r.dmp.DiffLevenshtein(r.dmp.DiffMain(prev[s], text[s], true))
+	}
+	if dist <= r.minChg {
+		slog.Debug("Ignoring small change", "lev", dist, "min", r.minChg)
+		return false
+	}
+	return true
+}
+
+// settled tells whether a change of the output to text is to be recorded:
+// only once the same output came back for the whole debounce period.
+func (r *runner) settled(isDifferent bool, text [numStreams]string, now time.Time) bool {
+	switch {
+	case !isDifferent:
+		// Back to the recorded output
+		r.pending = nil
+		return false
+	case changed(r.pending, text):
+		slog.Debug("Output changed, waiting for it to settle", "debounce", r.debounce)
+		r.pending, r.pendAt = &text, now
+		return false
+	case now.Sub(r.pendAt) < r.debounce:
+		return false
+	default:
+		r.pending = nil
+		return true
+	}
+}
+
+// next shortens d, the wait until the next run, for a pending change to be
+// checked as soon as it could be stable.
+func (r runner) next(d time.Duration) time.Duration {
+	if r.pending != nil {
+		return min(d, r.debounce)
+	}
+	return d
+}
+
+// earlyExit is a reason to stop watching.
+type earlyExit struct {
+	code   int
+	reason string
+	// Whether the command could not run at all
+	failed bool
+}
+
+// print tells why we exit, failures even when asked to be quiet.
+func (e earlyExit) print() {
+	if e.failed {
+		printErr(e.reason)
+	} else {
+		printExitReason(e.reason)
+	}
+}
+
+// checkExit tells whether a run calls for exiting, given the error it returned
+// and whether its output changed from an earlier one.
+func (r runner) checkExit(err error, changedFromEarlier bool) *earlyExit {
+	if err != nil {
+		var ee *exec.ExitError
+		switch {
+		case errors.Is(err, errPending):
+			// Expected until the condition holds
+		case !errors.As(err, &ee):
+			return &earlyExit{code: exitFailure, reason: fmt.Sprintf("Failed to run command: %v", err), failed: true}
+		case !ee.Success() && r.errExit:
+			return &earlyExit{code: ee.ExitCode(), reason: errTxtExit, failed: false}
+		}
+	}
+	if r.probe != nil && err == nil {
+		return &earlyExit{code: 0, reason: errTxtUntil, failed: false}
+	}
+	if r.chgExit && changedFromEarlier {
+		return &earlyExit{code: r.chgCode, reason: errTxtChg, failed: false}
+	}
+	return nil
+}
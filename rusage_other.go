@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func newResUsage(*os.ProcessState) *resUsage { return nil }
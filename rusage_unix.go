@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+func newResUsage(ps *os.ProcessState) *resUsage {
+	if ps == nil {
+		return nil
+	}
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return nil
+	}
+	maxRSS := int64(ru.Maxrss) //nolint:unconvert // Not int64 on every platform
+	// Linux and the BSDs report kilobytes, macOS bytes
+	if runtime.GOOS != "darwin" {
+		maxRSS *= 1024
+	}
+	return &resUsage{
+		user:   time.Duration(ru.Utime.Nano()),
+		sys:    time.Duration(ru.Stime.Nano()),
+		maxRSS: maxRSS,
+	}
+}
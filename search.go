@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+var searchMatchStyle = lipgloss.NewStyle().Foreground(colorDark).Background(colorPink)
+
+// search highlights query in the pager, and keeps highlighting it as the
+// content changes. An empty query clears the search.
+func (m *model) search(query string) {
+	slog.Debug("Searching output", "query", query)
+	m.query = query
+	var s string
+	s, m.matches = highlightMatches(m.content, m.query)
+	m.pager.SetContent(s)
+}
+
+// highlightMatches highlights every occurrence of query in s, which may be
+// styled already, and tells how many there are.
+func highlightMatches(s, query string) (string, int) {
+	if len(query) == 0 {
+		return s, 0
+	}
+	var (
+		sb strings.Builder
+		n  int
+	)
+	for line := range strings.Lines(s) {
+		line, nl := strings.CutSuffix(line, "\n")
+		plain := ansi.Strip(line)
+		var ranges []lipgloss.Range
+		for i := 0; ; {
+			j := strings.Index(plain[i:], query)
+			if j < 0 {
+				break
+			}
+			start, end := i+j, i+j+len(query)
+			// Ranges are in cells rather than bytes
+			ranges = append(ranges, lipgloss.NewRange(
+				ansi.StringWidth(plain[:start]), ansi.StringWidth(plain[:end]), searchMatchStyle))
+			i = end
+		}
+		n += len(ranges)
+		sb.WriteString(lipgloss.StyleRanges(line, ranges...))
+		if nl {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String(), n
+}
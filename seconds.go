@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// secondsValue is a duration flag which also takes a bare number of seconds,
+// as watch(1) does.
+type secondsValue time.Duration
+
+// secondsP defines a flag taking either a duration or a number of seconds.
+func secondsP(name, shorthand string, value time.Duration, usage string) *time.Duration {
+	p := new(time.Duration)
+	*p = value
+	flag.VarP((*secondsValue)(p), name, shorthand, usage)
+	return p
+}
+
+func (s *secondsValue) Set(v string) error {
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		if math.IsNaN(secs) || math.IsInf(secs, 0) {
+			return fmt.Errorf("invalid number of seconds %q", v)
+		}
+		*s = secondsValue(secs * float64(time.Second))
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*s = secondsValue(d)
+	return nil
+}
+
+func (s *secondsValue) String() string { return time.Duration(*s).String() }
+
+func (s *secondsValue) Type() string { return "duration" }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// startStatusServer serves the metrics of the latest run on addr:
+//   - /metrics in the Prometheus text format;
+//   - /healthz answering 200 when the latest run succeeded, 503 otherwise.
+func startStatusServer(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, _ *http.Request) {
+		rm, _ := latestMetrics()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, rm.prometheus())
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		rm, ok := latestMetrics()
+		switch {
+		case !ok:
+			http.Error(w, "no run yet", http.StatusServiceUnavailable)
+		case rm.exitCode != 0:
+			http.Error(w, fmt.Sprintf("last run exited with %d", rm.exitCode), http.StatusServiceUnavailable)
+		default:
+			fmt.Fprintln(w, "ok")
+		}
+	})
+	return serveHTTP(addr, mux)
+}
+
+// serveHTTP starts serving the handler on addr in the background.
+func serveHTTP(addr string, h http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 5 * time.Second} //nolint:exhaustruct // Defaults are fine
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			slog.Error("HTTP server stopped", "err", err)
+		}
+	}()
+	slog.Info("HTTP server listening", "addr", ln.Addr())
+	return nil
+}
+
+var (
+	lastOutputMu sync.Mutex
+	// Latest output of the command, and the one it changed from
+	lastOutput, prevOutput string
+)
+
+// publishOutput makes the latest output available to the output page.
+func publishOutput(cur, prev string) {
+	lastOutputMu.Lock()
+	defer lastOutputMu.Unlock()
+	lastOutput, prevOutput = cur, prev
+}
+
+var pageTmpl = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>a555watch: {{.Command}}</title>
+<style>
+body { font-family: monospace; }
+pre, .diff { white-space: pre-wrap; border: 1px solid #ccc; padding: 0.5em; }
+</style>
+</head>
+<body>
+<h3>Every {{.Interval}}: {{.Command}}</h3>
+<pre>{{.Output}}</pre>
+{{if .Diff}}<h3>Changes</h3>
+<div class="diff">{{.Diff}}</div>{{end}}
+</body>
+</html>
+`))
+
+// startPageServer serves on addr a page showing the latest output and its
+// diff from the previous one, refreshing on every interval. Addresses
+// without a host are bound to localhost.
+func startPageServer(addr string, cmd string, interval time.Duration) error {
+	if host, port, err := net.SplitHostPort(addr); err == nil && len(host) == 0 {
+		addr = net.JoinHostPort("localhost", port)
+	} else if err != nil && !strings.Contains(addr, ":") {
+		addr = net.JoinHostPort("localhost", addr)
+	}
+	refresh := max(1, int((interval+time.Second-1)/time.Second))
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, _ *http.Request) {
+		lastOutputMu.Lock()
+		cur, prev := lastOutput, prevOutput
+		lastOutputMu.Unlock()
+		var diff template.HTML
+		if len(prev) > 0 {
+			dmp := newDiffMatchPatch()
+			diffs := dmp.DiffCleanupSemantic(dmp.DiffMain(prev, cur, true))
+			if *flagMarkers {
+				diffs = markDiffs(diffs)
+			}
+			diff = template.HTML(dmp.DiffPrettyHtml(diffs)) //nolint:gosec // The text is escaped
+		}
+		err := pageTmpl.Execute(w, map[string]any{
+			"Refresh":  refresh,
+			"Interval": interval,
+			"Command":  cmd,
+			"Output":   cur,
+			"Diff":     diff,
+		})
+		if err != nil {
+			slog.Warn("Cannot render output page", "err", err)
+		}
+	})
+	return serveHTTP(addr, mux)
+}
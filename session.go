@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// session is the history of a watch, as saved with --save.
+type session struct {
+	Command []string       `json:"command"`
+	Entries []sessionEntry `json:"entries"`
+}
+
+// sessionEntry is an output of the command, entries are sorted chronologically.
+type sessionEntry struct {
+	Time     time.Time `json:"time"`
+	Stdout   string    `json:"stdout"`
+	Stderr   string    `json:"stderr"`
+	Combined string    `json:"combined"`
+}
+
+func (m model) session() session {
+	s := session{Command: m.cmd, Entries: nil}
+	for id := m.prevID; id != nil; id = m.hist[*id].prevID {
+		h := m.hist[*id]
+		s.Entries = append(s.Entries, sessionEntry{
+			Time: h.t, Stdout: h.plain, Stderr: h.stderr, Combined: h.combined,
+		})
+	}
+	slices.Reverse(s.Entries)
+	return s
+}
+
+var saveOnce sync.Once
+
+// saveSession writes the session to the file given with --save, if any.
+// Only the first call has any effect, so that every quit path can call it.
+func saveSession(s func() session) {
+	if len(*flagSave) == 0 {
+		return
+	}
+	saveOnce.Do(func() {
+		data, err := json.Marshal(s())
+		if err == nil {
+			err = os.WriteFile(*flagSave, data, 0o600)
+		}
+		if err != nil {
+			printErrf("Cannot save session: %v", err)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// runTTY runs c attached to a pseudo-terminal of the given size and returns
+// everything it wrote to the terminal, stdout and stderr alike.
+func runTTY(c *exec.Cmd, width, height int) ([]byte, error) {
+	if width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	ws := pty.Winsize{
+		Rows: uint16(min(height, math.MaxUint16)), //nolint:gosec // Clamped above
+		Cols: uint16(min(width, math.MaxUint16)),  //nolint:gosec // Clamped above
+		X:    0,
+		Y:    0,
+	}
+	ptmx, err := pty.StartWithSize(c, &ws)
+	if err != nil {
+		return nil, err
+	}
+	defer ptmx.Close()
+
+	var buf bytes.Buffer
+	// Reading fails with EIO once the child closes its side of the terminal.
+	_, _ = io.Copy(&buf, ptmx)
+	err = c.Wait()
+	// Terminals translate newlines to CRLF, undo it to keep the diffs sane.
+	return bytes.ReplaceAll(buf.Bytes(), []byte("\r\n"), []byte("\n")), err
+}